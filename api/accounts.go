@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"tier.run/control"
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/trweb"
+)
+
+var errAccountNotAllowed = trweb.Error(403, "account_not_allowed", "account not allowed")
+
+type clientKey struct{}
+
+// client returns the control client for the account r is made on behalf of.
+func (h *Handler) client(r *http.Request) *control.Client {
+	if c, ok := r.Context().Value(clientKey{}).(*control.Client); ok {
+		return c
+	}
+	return h.c
+}
+
+// accountClient returns the control client used for requests made on behalf
+// of the Stripe connected account with the provided ID. If id is empty or
+// the account the Handler was created with, the Handler's client is
+// returned.
+//
+// Each account has its own client, and so its own caches, which live for
+// the life of the Handler.
+func (h *Handler) accountClient(id string) (*control.Client, error) {
+	if id == "" || id == h.c.Stripe.AccountID {
+		return h.c, nil
+	}
+	if !slices.Contains(h.Accounts, id) {
+		return nil, errAccountNotAllowed
+	}
+
+	h.accountsMu.Lock()
+	defer h.accountsMu.Unlock()
+	c := h.accounts[id]
+	if c == nil {
+		c = &control.Client{
+			Logf:      h.c.Logf,
+			Stripe:    h.c.Stripe.CloneAs(id),
+			KeySource: h.c.KeySource,
+			Cache:     h.c.Cache,
+		}
+		if h.accounts == nil {
+			h.accounts = make(map[string]*control.Client)
+		}
+		h.accounts[id] = c
+	}
+	return c, nil
+}
+
+func withClient(ctx context.Context, c *control.Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
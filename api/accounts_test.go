@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/stripe"
+)
+
+func TestAccounts(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{} // by Stripe-Account
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acct := r.Header.Get("Stripe-Account")
+		mu.Lock()
+		calls[acct]++
+		mu.Unlock()
+		fmt.Fprintf(w, `{"data": [{"id": "cus_%s", "metadata": {"tier.org": "org:a"}}]}`, acct)
+	}))
+	defer s.Close()
+
+	cc := &control.Client{
+		Stripe: &stripe.Client{
+			BaseURL:    s.URL,
+			HTTPClient: s.Client(),
+			AccountID:  "acct_home",
+			Logf:       t.Logf,
+		},
+		Logf: t.Logf,
+	}
+	h := NewHandler(cc, t.Logf)
+	h.Accounts = []string{"acct_1", "acct_2"}
+	hs := httptest.NewServer(h)
+	defer hs.Close()
+	tc := &tier.Client{BaseURL: hs.URL, HTTPClient: hs.Client(), Logf: t.Logf}
+
+	ctx := context.Background()
+	cases := []struct {
+		account string
+		want    string
+	}{
+		{"", "cus_acct_home"},
+		{"acct_home", "cus_acct_home"},
+		{"acct_1", "cus_acct_1"},
+		{"acct_2", "cus_acct_2"},
+	}
+	for _, tt := range cases {
+		for i := 0; i < 2; i++ { // second lookup is cached
+			got, err := tc.WhoIs(tc.WithAccount(ctx, tt.account), "org:a")
+			if err != nil {
+				t.Fatalf("WhoIs(%q): %v", tt.account, err)
+			}
+			if got.StripeID != tt.want {
+				t.Errorf("WhoIs(%q) = %q; want %q", tt.account, got.StripeID, tt.want)
+			}
+		}
+	}
+	for _, acct := range []string{"acct_home", "acct_1", "acct_2"} {
+		if calls[acct] != 1 {
+			t.Errorf("calls[%q] = %d; want 1", acct, calls[acct])
+		}
+	}
+
+	_, err := tc.WhoIs(tc.WithAccount(ctx, "acct_other"), "org:a")
+	if !isAPIErrorCode(err, "account_not_allowed") {
+		t.Errorf("err = %v; want account_not_allowed", err)
+	}
+	if calls["acct_other"] != 0 {
+		t.Error("request made for account not allowed")
+	}
+}
@@ -6,17 +6,22 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"tier.run/api/apitypes"
 	"tier.run/api/materialize"
+	"tier.run/audit"
 	"tier.run/client/tier"
 	"tier.run/control"
+	"tier.run/metrics"
 	"tier.run/mirror/x/exp/slices"
 	"tier.run/refs"
 	"tier.run/stripe"
 	"tier.run/trweb"
+	"tier.run/values"
 )
 
 func init() {
@@ -74,6 +79,16 @@ var errorLookup = map[error]*trweb.HTTPError{
 		Code:    "invalid_metadata",
 		Message: "metadata keys must not use reserved prefix ('tier.')",
 	},
+	control.ErrMixedCurrencies: {
+		Status:  400,
+		Code:    "mixed_currencies",
+		Message: "features have different currencies",
+	},
+	control.ErrNegativeUsage: {
+		Status:  400,
+		Code:    "negative_usage",
+		Message: "usage must not be negative",
+	},
 	stripe.ErrInvalidAPIKey: {
 		Status:  401,
 		Code:    "invalid_api_key",
@@ -92,10 +107,52 @@ func lookupErr(err error) error {
 	}
 }
 
+var (
+	metricRequests = metrics.NewCounterVec("tier_http_requests_total",
+		"Sidecar API requests by route and HTTP status code.",
+		"route", "code")
+	metricRequestDuration = metrics.NewHistogramVec("tier_http_request_duration_seconds",
+		"Latency of sidecar API requests by route and HTTP status code.", nil,
+		"route", "code")
+)
+
+// errNoRoute is returned by serve for unknown paths.
+var errNoRoute = trweb.Error(404, "not_found", "Not Found")
+
 type Handler struct {
-	Logf   func(format string, args ...any)
+	Logf func(format string, args ...any)
+
+	// Auth, if not nil, specifies the tokens required to use the API.
+	// If nil, all requests are allowed.
+	Auth *Auth
+
+	// Accounts lists the Stripe connected accounts that requests may be
+	// made on behalf of using the Tier-Account header. Requests naming
+	// any other account are rejected.
+	Accounts []string
+
+	// ModelTTL is how long the pricing model used to subscribe, check
+	// out, and quote is cached. The model is also refreshed after pushes,
+	// on webhook events for prices and products, and on requests to
+	// /v1/refresh. If zero, it is fetched on each request.
+	ModelTTL time.Duration
+
+	// WebhookSecret, if set, is the signing secret of a Stripe webhook
+	// endpoint that sends events to /v1/webhooks/stripe. If empty, the
+	// endpoint is not served.
+	WebhookSecret string
+
+	// Audit, if not nil, records requests that change state in Stripe,
+	// such as subscribing orgs and pushing pricing models.
+	Audit audit.Sink
+
 	c      *control.Client
 	helper func()
+	ready  readyCache
+	models modelCache
+
+	accountsMu sync.Mutex
+	accounts   map[string]*control.Client // by account ID
 }
 
 func NewHandler(c *control.Client, logf func(string, ...any)) *Handler {
@@ -108,9 +165,25 @@ func isInvalidAccount(err error) bool {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusResponseWriter{ResponseWriter: w, status: 200}
+	w = sw
+	route := "other"
+	defer func() {
+		code := strconv.Itoa(sw.status)
+		metricRequests.Inc(route, code)
+		metricRequestDuration.Observe(time.Since(start).Seconds(), route, code)
+	}()
+
 	var err error
+	if h.Audit != nil {
+		var rec auditRecord
+		r = withAuditRecord(r, &rec)
+		defer func() { h.finishAudit(&rec, sw.status, err) }()
+	}
+
 	bw := &byteCountResponseWriter{ResponseWriter: w}
-	err = h.serve(bw, r)
+	route, err = h.serve(bw, r)
 	if err != nil {
 		h.Logf("%s %s %s %s: %v", r.RemoteAddr, r.Method, r.Host, r.URL, err)
 	}
@@ -123,6 +196,12 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var ps materialize.Problems
+	if errors.As(err, &ps) {
+		writeProblems(w, ps)
+		return
+	}
+
 	var ipe *stripe.Error
 	if errors.As(err, &ipe) && strings.Contains(ipe.Message, "No such PaymentMethod") {
 		trweb.WriteError(w, &trweb.HTTPError{
@@ -163,38 +242,84 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
-	clockID := r.Header.Get(tier.ClockHeader)
-	r = r.Clone(control.WithClock(r.Context(), clockID))
-
-	switch r.URL.Path {
+// serve serves r with the handler for its route, and returns the route for
+// use as a metric label, or "other" if r is for an unknown path.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) (route string, err error) {
+	route = r.URL.Path
+	var serve func(http.ResponseWriter, *http.Request) error
+	switch route {
+	// health checks are made by orchestrators that do not hold tokens
+	case "/healthz":
+		return route, h.serveHealthz(w, r)
+	case "/readyz":
+		return route, h.serveReadyz(w, r)
+	case "/v1/openapi.json":
+		return route, h.serveOpenAPI(w, r)
+	case "/v1/webhooks/stripe":
+		// Stripe authenticates itself with signatures, not tokens
+		return route, h.serveStripeWebhook(w, r)
 	case "/v1/whoami":
-		return h.serveWhoAmI(w, r)
+		serve = h.serveWhoAmI
 	case "/v1/whois":
-		return h.serveWhoIs(w, r)
+		serve = h.serveWhoIs
 	case "/v1/limits":
-		return h.serveLimits(w, r)
+		serve = h.serveLimits
 	case "/v1/report":
-		return h.serveReport(w, r)
+		serve = h.serveReport
+	case "/v1/consume":
+		serve = h.serveConsume
 	case "/v1/subscribe":
-		return h.serveSubscribe(w, r)
+		serve = h.serveSubscribe
 	case "/v1/checkout":
-		return h.serveCheckout(w, r)
+		serve = h.serveCheckout
 	case "/v1/phases":
-		return h.servePhases(w, r)
+		serve = h.servePhases
 	case "/v1/phase":
-		return h.servePhase(w, r)
+		serve = h.servePhase
 	case "/v1/pull":
-		return h.servePull(w, r)
+		serve = h.servePull
 	case "/v1/push":
-		return h.servePush(w, r)
+		serve = h.servePush
+	case "/v1/refresh":
+		serve = h.serveRefresh
+	case "/v1/quote":
+		serve = h.serveQuote
+	case "/v1/drift":
+		serve = h.serveDrift
+	case "/v1/reports/revenue":
+		serve = h.serveRevenue
 	case "/v1/payment_methods":
-		return h.servePaymentMethods(w, r)
+		serve = h.servePaymentMethods
 	case "/v1/clock":
-		return h.serveClock(w, r)
+		serve = h.serveClock
+	case "/v1/clocks":
+		serve = h.serveClocks
 	default:
-		return trweb.NotFound
+		route = "other"
 	}
+
+	if h.Auth != nil {
+		if err := h.Auth.check(r); err != nil {
+			return route, err
+		}
+	}
+
+	c, err := h.accountClient(r.Header.Get(tier.AccountHeader))
+	if err != nil {
+		return route, err
+	}
+	if serve == nil {
+		return route, errNoRoute
+	}
+	if h.Audit != nil && isAudited(r.Method, route) {
+		r, err = h.startAudit(w, r)
+		if err != nil {
+			return route, err
+		}
+	}
+	clockID := r.Header.Get(tier.ClockHeader)
+	r = r.Clone(withClient(control.WithClock(r.Context(), clockID), c))
+	return route, serve(w, r)
 }
 
 func (h *Handler) serveCheckout(w http.ResponseWriter, r *http.Request) error {
@@ -202,15 +327,15 @@ func (h *Handler) serveCheckout(w http.ResponseWriter, r *http.Request) error {
 	if err := trweb.DecodeStrict(r, &cr); err != nil {
 		return err
 	}
-	m, err := h.c.Pull(r.Context(), 0)
-	if err != nil {
+	var fs []control.Feature
+	_, err := h.expandModel(r, func(m []control.Feature) (err error) {
+		fs, err = control.ExpandPlans(m, cr.Features...)
 		return err
-	}
-	fs, err := control.ExpandPlans(m, cr.Features...)
+	})
 	if err != nil {
 		return err
 	}
-	link, err := h.c.Checkout(r.Context(), cr.Org, cr.SuccessURL, &control.CheckoutParams{
+	link, err := h.client(r).Checkout(r.Context(), cr.Org, cr.SuccessURL, &control.CheckoutParams{
 		TrialDays:             cr.TrialDays,
 		Features:              fs,
 		CancelURL:             cr.CancelURL,
@@ -231,7 +356,7 @@ func (h *Handler) serveSubscribe(w http.ResponseWriter, r *http.Request) error {
 	}
 	if sr.Info != nil {
 		info := infoToOrgInfo(sr.Info)
-		if err := h.c.PutCustomer(r.Context(), sr.Org, info); err != nil {
+		if err := h.client(r).PutCustomer(r.Context(), sr.Org, info); err != nil {
 			return err
 		}
 	}
@@ -240,30 +365,35 @@ func (h *Handler) serveSubscribe(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	var phases []control.Phase
+	var m []control.Feature
 	if len(sr.Phases) > 0 {
-		m, err := h.c.Pull(r.Context(), 0)
+		var err error
+		m, err = h.expandModel(r, func(m []control.Feature) error {
+			phases = phases[:0]
+			for _, p := range sr.Phases {
+				fs, err := control.Expand(m, p.Features...)
+				if err != nil {
+					return err
+				}
+				phases = append(phases, control.Phase{
+					Trial:        p.Trial,
+					Effective:    p.Effective,
+					Features:     fs,
+					AutomaticTax: sr.Tax.Automatic,
+					Coupon:       p.Coupon,
+				})
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
-
-		for _, p := range sr.Phases {
-			fs, err := control.Expand(m, p.Features...)
-			if err != nil {
-				return err
-			}
-			phases = append(phases, control.Phase{
-				Trial:        p.Trial,
-				Effective:    p.Effective,
-				Features:     fs,
-				AutomaticTax: sr.Tax.Automatic,
-				Coupon:       p.Coupon,
-			})
-		}
 	}
 
-	return h.c.Schedule(r.Context(), sr.Org, control.ScheduleParams{
+	return h.client(r).Schedule(r.Context(), sr.Org, control.ScheduleParams{
 		PaymentMethod: sr.PaymentMethodID,
 		Phases:        phases,
+		Model:         m,
 	})
 }
 
@@ -273,16 +403,44 @@ func (h *Handler) serveReport(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return h.c.ReportUsage(r.Context(), rr.Org, rr.Feature, control.Report{
-		N:       rr.N,
-		At:      rr.At,
-		Clobber: rr.Clobber,
+	key := r.Header.Get("Idempotency-Key")
+	if key != "" && rr.IdempotencyKey != "" && key != rr.IdempotencyKey {
+		return trweb.Error(400, "invalid_request", "Idempotency-Key header and idempotency_key field differ")
+	}
+
+	return h.client(r).ReportUsage(r.Context(), rr.Org, rr.Feature, control.Report{
+		N:              rr.N,
+		At:             rr.At,
+		Clobber:        rr.Clobber,
+		IdempotencyKey: values.Coalesce(rr.IdempotencyKey, key),
+	})
+}
+
+func (h *Handler) serveConsume(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return trweb.MethodNotAllowed
+	}
+	var cr apitypes.ConsumeRequest
+	if err := trweb.DecodeStrict(r, &cr); err != nil {
+		return err
+	}
+	c, err := h.client(r).Consume(r.Context(), cr.Org, cr.Feature, cr.N)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.ConsumeResponse{
+		Org:       cr.Org,
+		Feature:   cr.Feature,
+		OK:        c.Allowed,
+		Used:      c.Used,
+		Limit:     c.Limit,
+		Remaining: c.Remaining(),
 	})
 }
 
 func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) error {
 	org := r.FormValue("org")
-	stripeID, err := h.c.WhoIs(r.Context(), org)
+	stripeID, err := h.client(r).WhoIs(r.Context(), org)
 	if err != nil {
 		return err
 	}
@@ -290,7 +448,7 @@ func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) error {
 	res := &apitypes.WhoIsResponse{Org: org, StripeID: stripeID}
 	inc := r.URL.Query()["include"]
 	if slices.Contains(inc, "info") {
-		info, err := h.c.LookupOrg(r.Context(), org)
+		info, err := h.client(r).LookupOrg(r.Context(), org)
 		if err != nil {
 			return err
 		}
@@ -310,7 +468,7 @@ func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (h *Handler) serveWhoAmI(w http.ResponseWriter, r *http.Request) error {
-	who, err := h.c.WhoAmI(r.Context())
+	who, err := h.client(r).WhoAmI(r.Context())
 	if err != nil {
 		return err
 	}
@@ -327,7 +485,7 @@ func (h *Handler) serveWhoAmI(w http.ResponseWriter, r *http.Request) error {
 // EXPERIMENTAL (undocumented)
 func (h *Handler) servePhases(w http.ResponseWriter, r *http.Request) error {
 	org := r.FormValue("org")
-	s, err := h.c.LookupPhases(r.Context(), org)
+	s, err := h.client(r).LookupPhases(r.Context(), org)
 	if err != nil {
 		return err
 	}
@@ -363,7 +521,7 @@ func (h *Handler) servePhases(w http.ResponseWriter, r *http.Request) error {
 
 func (h *Handler) servePhase(w http.ResponseWriter, r *http.Request) error {
 	org := r.FormValue("org")
-	s, err := h.c.LookupPhases(r.Context(), org)
+	s, err := h.client(r).LookupPhases(r.Context(), org)
 	if err != nil {
 		return err
 	}
@@ -397,7 +555,7 @@ func (h *Handler) servePhase(w http.ResponseWriter, r *http.Request) error {
 
 func (h *Handler) serveLimits(w http.ResponseWriter, r *http.Request) error {
 	org := r.FormValue("org")
-	usage, err := h.c.LookupLimits(r.Context(), org)
+	usage, err := h.client(r).LookupLimits(r.Context(), org)
 	if err != nil {
 		return err
 	}
@@ -416,7 +574,7 @@ func (h *Handler) serveLimits(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (h *Handler) servePull(w http.ResponseWriter, r *http.Request) error {
-	m, err := h.c.Pull(r.Context(), 0)
+	m, err := h.client(r).Pull(r.Context(), 0)
 	if err != nil {
 		return err
 	}
@@ -434,12 +592,13 @@ func (h *Handler) servePush(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	fs, err := materialize.FromPricingHuJSON(data)
+	fs, err := materialize.FromPricing(data, materialize.FormatFromContentType(r.Header.Get("Content-Type")))
 	if err != nil {
 		return err
 	}
+	setAuditSummary(r.Context(), summarizePush(fs))
 	var ee []apitypes.PushResult
-	_ = h.c.Push(r.Context(), fs, func(f control.Feature, err error) {
+	_ = h.client(r).Push(r.Context(), fs, func(f control.Feature, err error) {
 		pr := apitypes.PushResult{
 			Feature: f.FeaturePlan,
 		}
@@ -456,13 +615,90 @@ func (h *Handler) servePush(w http.ResponseWriter, r *http.Request) error {
 		}
 		ee = append(ee, pr)
 	})
+	// refresh even if some features failed to push, since others may
+	// have been created
+	h.models.invalidate(h.client(r).Stripe.AccountID)
 	return httpJSON(w, apitypes.PushResponse{Results: ee})
 }
 
+func (h *Handler) serveDrift(w http.ResponseWriter, r *http.Request) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	fs, err := materialize.FromPricing(data, materialize.FormatFromContentType(r.Header.Get("Content-Type")))
+	if err != nil {
+		return err
+	}
+	ds, err := h.client(r).Drift(r.Context(), fs)
+	if err != nil {
+		return err
+	}
+	dr := apitypes.DriftResponse{Drift: []apitypes.Drift{}}
+	for _, d := range ds {
+		dr.Drift = append(dr.Drift, apitypes.Drift{
+			Kind:       string(d.Kind),
+			Plan:       d.Plan,
+			Feature:    d.Feature,
+			ProviderID: d.ProviderID,
+			Field:      d.Field,
+			Want:       d.Want,
+			Got:        d.Got,
+		})
+	}
+	return httpJSON(w, dr)
+}
+
+func (h *Handler) serveRevenue(w http.ResponseWriter, r *http.Request) error {
+	rs, err := h.client(r).Revenue(r.Context())
+	if err != nil {
+		return err
+	}
+	rr := apitypes.RevenueResponse{Plans: []apitypes.PlanRevenue{}}
+	for _, p := range rs {
+		rr.Plans = append(rr.Plans, apitypes.PlanRevenue{
+			Plan:     p.Plan,
+			Currency: p.Currency,
+			Orgs:     p.Orgs,
+			Licensed: p.Licensed,
+			Metered:  p.Metered,
+			MRR:      p.MRR(),
+		})
+	}
+	return httpJSON(w, rr)
+}
+
+func (h *Handler) serveQuote(w http.ResponseWriter, r *http.Request) error {
+	var qr apitypes.QuoteRequest
+	if err := trweb.DecodeStrict(r, &qr); err != nil {
+		return err
+	}
+	var fs []control.Feature
+	_, err := h.expandModel(r, func(m []control.Feature) (err error) {
+		fs, err = control.ExpandPlans(m, qr.Features...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	q, err := control.QuoteFeatures(fs, qr.Usage)
+	if err != nil {
+		return err
+	}
+	rr := apitypes.QuoteResponse{
+		Currency: q.Currency,
+		Total:    q.Total,
+	}
+	for _, l := range q.Lines {
+		rr.Lines = append(rr.Lines, apitypes.QuoteLine(l))
+	}
+	return httpJSON(w, rr)
+}
+
 func (h *Handler) servePaymentMethods(w http.ResponseWriter, r *http.Request) error {
 	org := r.FormValue("org")
 
-	pms, err := h.c.LookupPaymentMethods(r.Context(), org)
+	pms, err := h.client(r).LookupPaymentMethods(r.Context(), org)
 	if err != nil {
 		return err
 	}
@@ -473,24 +709,35 @@ func (h *Handler) servePaymentMethods(w http.ResponseWriter, r *http.Request) er
 	})
 }
 
+func clockResponse(c *control.Clock) apitypes.ClockResponse {
+	return apitypes.ClockResponse{
+		ID:      c.ID(),
+		Name:    c.Name(),
+		Link:    c.Link(),
+		Present: c.Present(),
+		Status:  c.Status(),
+	}
+}
+
 func (h *Handler) serveClock(w http.ResponseWriter, r *http.Request) error {
 	writeResp := func(c *control.Clock) error {
-		return httpJSON(w, apitypes.ClockResponse{
-			ID:      c.ID(),
-			Link:    c.Link(),
-			Present: c.Present(),
-			Status:  c.Status(),
-		})
+		return httpJSON(w, clockResponse(c))
 	}
 
 	switch r.Method {
 	case "GET":
 		clockID := r.FormValue("id")
-		c := h.c.ClockFromID(clockID)
+		c := h.client(r).ClockFromID(clockID)
 		if err := c.Sync(r.Context()); err != nil {
 			return err
 		}
 		return writeResp(c)
+	case "DELETE":
+		clockID := r.FormValue("id")
+		if clockID == "" {
+			return trweb.InvalidRequest
+		}
+		return h.client(r).ClockFromID(clockID).Delete(r.Context())
 	case "POST":
 		var v apitypes.ClockRequest
 		if err := trweb.DecodeStrict(r, &v); err != nil {
@@ -498,13 +745,13 @@ func (h *Handler) serveClock(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		if v.ID == "" {
-			c, err := h.c.NewClock(r.Context(), v.Name, v.Present)
+			c, err := h.client(r).NewClock(r.Context(), v.Name, v.Present)
 			if err != nil {
 				return err
 			}
 			return writeResp(c)
 		} else {
-			c := h.c.ClockFromID(v.ID)
+			c := h.client(r).ClockFromID(v.ID)
 			if err := c.Advance(r.Context(), v.Present); err != nil {
 				return err
 			}
@@ -515,6 +762,38 @@ func (h *Handler) serveClock(w http.ResponseWriter, r *http.Request) error {
 	}
 }
 
+func (h *Handler) serveClocks(w http.ResponseWriter, r *http.Request) error {
+	clocks, err := h.client(r).Clocks(r.Context())
+	if err != nil {
+		return err
+	}
+	var cr apitypes.ClocksResponse
+	for _, c := range clocks {
+		cr.Clocks = append(cr.Clocks, clockResponse(c))
+	}
+	return httpJSON(w, cr)
+}
+
+// writeProblems writes an invalid_pricing error listing the problems in ps.
+func writeProblems(w http.ResponseWriter, ps materialize.Problems) {
+	e := apitypes.Error{
+		Status:  400,
+		Code:    "invalid_pricing",
+		Message: ps.Error(),
+	}
+	for _, p := range ps {
+		e.Problems = append(e.Problems, apitypes.PricingProblem{
+			Path:    p.Path,
+			Line:    p.Line,
+			Column:  p.Column,
+			Message: p.Message,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	httpJSON(w, e)
+}
+
 func httpJSON(w http.ResponseWriter, v any) error {
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
@@ -522,6 +801,16 @@ func httpJSON(w http.ResponseWriter, v any) error {
 	return enc.Encode(v)
 }
 
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
 type byteCountResponseWriter struct {
 	http.ResponseWriter
 	n int
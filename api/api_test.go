@@ -2,10 +2,15 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -309,7 +314,7 @@ func TestScheduleAutomaticTax(t *testing.T) {
 				"tier.plan": "plan:test@0",
 				"tier.feature": "feature:t@plan:test@0"
 			}}]}`)
-		case they.Want(r, "GET", "/v1/customers"):
+		case they.Want(r, "GET", "/v1/customers(/search)?"):
 			io.WriteString(w, `{"data":[{"id": "cus_123", "meatadata": {
 				"tier.org": "org:test"
 			}}]}`)
@@ -638,6 +643,36 @@ func TestPushInvalidPrice(t *testing.T) {
 	})
 }
 
+func TestPushProblems(t *testing.T) {
+	h := NewHandler(nil, t.Logf) // invalid pricing is rejected before Stripe is used
+
+	body := `{
+		"plans": {
+			"plan:test@0": {
+				"features": {
+					"feature:t": {"mode": "stacked"}
+				}
+			}
+		}
+	}`
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/v1/push", strings.NewReader(body)))
+
+	var e apitypes.Error
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 400 || e.Code != "invalid_pricing" {
+		t.Errorf("status, code = %d, %q; want 400, invalid_pricing", w.Code, e.Code)
+	}
+	diff.Test(t, t.Errorf, e.Problems, []apitypes.PricingProblem{{
+		Path:    `plans["plan:test@0"].features["feature:t"].mode`,
+		Line:    5,
+		Column:  28,
+		Message: `unknown value "stacked"; must be one of graduated, volume`,
+	}})
+}
+
 func TestWhoAmI(t *testing.T) {
 	t.Parallel()
 
@@ -686,6 +721,53 @@ func TestClock(t *testing.T) {
 	}
 }
 
+func TestClocksListDelete(t *testing.T) {
+	ctx := context.Background()
+	var deleted []string
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/test_helpers/test_clocks"):
+			io.WriteString(w, `{"data": [
+				{"id": "clock_2", "name": "b", "status": "advancing", "frozen_time": 1767225600},
+				{"id": "clock_1", "name": "a", "status": "ready", "frozen_time": 1767225600}
+			]}`)
+		case they.Want(r, "DELETE", "/v1/test_helpers/test_clocks/.*"):
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/v1/test_helpers/test_clocks/"))
+			io.WriteString(w, `{"deleted": true}`)
+		default:
+			t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	got, err := tc.ListClocks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	present := time.Unix(1767225600, 0)
+	diff.Test(t, t.Errorf, got, apitypes.ClocksResponse{
+		Clocks: []apitypes.ClockResponse{
+			{ID: "clock_2", Name: "b", Status: "advancing", Present: present,
+				Link: "https://dashboard.stripe.com/test/test-clocks/clock_2"},
+			{ID: "clock_1", Name: "a", Status: "ready", Present: present,
+				Link: "https://dashboard.stripe.com/test/test-clocks/clock_1"},
+		},
+	})
+
+	if err := tc.DeleteClock(ctx, "clock_1"); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, deleted, []string{"clock_1"})
+
+	err = tc.DeleteClock(ctx, "")
+	diff.Test(t, t.Errorf, err, &apitypes.Error{
+		Status:  400,
+		Code:    "invalid_request",
+		Message: "Invalid Request",
+	})
+}
+
 func TestTierReport(t *testing.T) {
 	t.Parallel()
 
@@ -888,3 +970,469 @@ func maybeFailNow(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestConsume(t *testing.T) {
+	ctx := context.Background()
+
+	const price = `{
+		"id": "price_123",
+		"metadata": {
+			"tier.feature": "feature:x@plan:test@0",
+			"tier.limit": "10"
+		},
+		"recurring": {
+			"usage_type": "metered",
+			"aggregate_usage": "sum"
+		}
+	}`
+
+	var mu sync.Mutex
+	used := 8
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case they.Want(r, "GET", "/v1/customers(/search)?"):
+			io.WriteString(w, `{"data":[{"id": "cus_123", "metadata": {
+				"tier.org": "org:test"
+			}}]}`)
+		case they.Want(r, "GET", "/v1/invoices/upcoming/lines"):
+			fmt.Fprintf(w, `{"data": [{"price": %s, "quantity": %d}]}`, price, used)
+		case they.Want(r, "GET", "/v1/subscriptions"):
+			fmt.Fprintf(w, `{"data": [{
+				"id": "sub_123",
+				"metadata": {"tier.subscription": "default"},
+				"items": {"data": [{"id": "si_123", "price": %s}]}
+			}]}`, price)
+		case they.Want(r, "POST", "/v1/subscription_items/si_123/usage_records"):
+			n, _ := strconv.Atoi(r.FormValue("quantity"))
+			used += n
+			io.WriteString(w, `{}`)
+		default:
+			t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	got, err := tc.Consume(ctx, "org:test", "feature:x", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.ConsumeResponse{
+		Org:       "org:test",
+		Feature:   mpn("feature:x"),
+		OK:        true,
+		Used:      10,
+		Limit:     10,
+		Remaining: 0,
+	})
+
+	got, err = tc.Consume(ctx, "org:test", "feature:x", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.ConsumeResponse{
+		Org:       "org:test",
+		Feature:   mpn("feature:x"),
+		OK:        false,
+		Used:      10,
+		Limit:     10,
+		Remaining: 0,
+	})
+	if used != 10 {
+		t.Errorf("used = %d; want 10", used)
+	}
+
+	_, err = tc.Consume(ctx, "org:test", "feature:nope", 1)
+	if !isAPIErrorCode(err, "feature_not_found") {
+		t.Errorf("err = %v; want feature_not_found", err)
+	}
+}
+
+func TestConsumeDelayedUsage(t *testing.T) {
+	ctx := context.Background()
+
+	const price = `{
+		"id": "price_123",
+		"metadata": {
+			"tier.feature": "feature:x@plan:test@0",
+			"tier.limit": "10"
+		},
+		"recurring": {
+			"usage_type": "metered",
+			"aggregate_usage": "sum"
+		}
+	}`
+
+	// visible is the usage shown in the upcoming invoice; unlike reported,
+	// it does not change when usage is reported, as at Stripe, where
+	// reports show in the invoice only after a delay.
+	var mu sync.Mutex
+	visible, reported := 6, 6
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case they.Want(r, "GET", "/v1/customers(/search)?"):
+			io.WriteString(w, `{"data":[{"id": "cus_123", "metadata": {
+				"tier.org": "org:test"
+			}}]}`)
+		case they.Want(r, "GET", "/v1/invoices/upcoming/lines"):
+			fmt.Fprintf(w, `{"data": [{"price": %s, "quantity": %d, "period": {"end": 1700000000}}]}`, price, visible)
+		case they.Want(r, "GET", "/v1/subscriptions"):
+			fmt.Fprintf(w, `{"data": [{
+				"id": "sub_123",
+				"metadata": {"tier.subscription": "default"},
+				"items": {"data": [{"id": "si_123", "price": %s}]}
+			}]}`, price)
+		case they.Want(r, "POST", "/v1/subscription_items/si_123/usage_records"):
+			n, _ := strconv.Atoi(r.FormValue("quantity"))
+			reported += n
+			io.WriteString(w, `{}`)
+		default:
+			t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	consume := func(n int, wantOK bool, wantUsed int) {
+		t.Helper()
+		got, err := tc.Consume(ctx, "org:test", "feature:x", n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.OK != wantOK || got.Used != wantUsed {
+			t.Errorf("Consume(%d) = OK %v, Used %d; want OK %v, Used %d", n, got.OK, got.Used, wantOK, wantUsed)
+		}
+	}
+
+	consume(3, true, 9)
+	consume(2, false, 9) // 6 visible, but 9 reported
+	consume(1, true, 10)
+	consume(1, false, 10)
+	if reported != 10 {
+		t.Errorf("reported = %d; want 10", reported)
+	}
+
+	// once Stripe catches up, its usage is used again
+	mu.Lock()
+	visible = 4 // e.g. after a report elsewhere set usage
+	mu.Unlock()
+	consume(1, false, 10) // not caught up yet
+	mu.Lock()
+	visible = 10
+	mu.Unlock()
+	consume(1, false, 10)
+	mu.Lock()
+	visible = 3
+	mu.Unlock()
+	consume(1, true, 4)
+}
+
+func isAPIErrorCode(err error, code string) bool {
+	var e *apitypes.Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+func TestReportIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var got []string
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/customers(/search)?"):
+			io.WriteString(w, `{"data":[{"id": "cus_123", "metadata": {
+				"tier.org": "org:test"
+			}}]}`)
+		case they.Want(r, "GET", "/v1/subscriptions"):
+			io.WriteString(w, `{"data": [{
+				"id": "sub_123",
+				"metadata": {"tier.subscription": "default"},
+				"items": {"data": [{"id": "si_123", "price": {
+					"metadata": {"tier.feature": "feature:x@plan:test@0"},
+					"recurring": {"usage_type": "metered", "aggregate_usage": "sum"}
+				}}]}
+			}]}`)
+		case they.Want(r, "POST", "/v1/subscription_items/si_123/usage_records"):
+			mu.Lock()
+			got = append(got, r.Header.Get("Idempotency-Key"))
+			mu.Unlock()
+			io.WriteString(w, `{}`)
+		default:
+			t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	if err := tc.ReportUsage(ctx, "org:test", "feature:x", 1, &tier.ReportParams{
+		IdempotencyKey: "evt_1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(key, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, "POST", tc.BaseURL+"/v1/report", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Idempotency-Key", key)
+		res, err := tc.HTTPClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	if res := post("evt_2", `{"org": "org:test", "feature": "feature:x", "n": 1}`); res.StatusCode != 200 {
+		t.Errorf("status = %d; want 200", res.StatusCode)
+	}
+	if res := post("evt_3", `{"org": "org:test", "feature": "feature:x", "n": 1, "idempotency_key": "evt_4"}`); res.StatusCode != 400 {
+		t.Errorf("status = %d; want 400", res.StatusCode)
+	}
+
+	diff.Test(t, t.Errorf, got, []string{
+		"usage:org:test:feature:x:evt_1",
+		"usage:org:test:feature:x:evt_2",
+	})
+}
+
+func TestRequestMetrics(t *testing.T) {
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+	})
+
+	before := metricRequests.Value("/v1/whois", "400")
+	beforeOther := metricRequests.Value("other", "404")
+	beforeForbidden := metricRequests.Value("other", "403")
+
+	ctx := context.Background()
+	if _, err := tc.WhoIs(ctx, "nope"); err == nil {
+		t.Fatal("expected error")
+	}
+	res, err := tc.HTTPClient.Get(tc.BaseURL + "/v1/does/not/exist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// rejected requests for unknown paths are not labeled by path either
+	req, err := http.NewRequest("GET", tc.BaseURL+"/v1/not/allowed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(tier.AccountHeader, "acct_other")
+	res, err = tc.HTTPClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 403 {
+		t.Errorf("status = %d; want 403", res.StatusCode)
+	}
+	if got := metricRequests.Value("/v1/not/allowed", "403"); got != 0 {
+		t.Errorf("requests labeled by unknown path = %v; want 0", got)
+	}
+	if got := metricRequests.Value("other", "403") - beforeForbidden; got != 1 {
+		t.Errorf("other forbidden requests = %v; want 1", got)
+	}
+
+	if got := metricRequests.Value("/v1/whois", "400") - before; got != 1 {
+		t.Errorf("whois requests = %v; want 1", got)
+	}
+	if got := metricRequests.Value("other", "404") - beforeOther; got != 1 {
+		t.Errorf("other requests = %v; want 1", got)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/prices"):
+			io.WriteString(w, `{"data": [{
+				"metadata": {"tier.feature": "feature:api@plan:pro@2"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "usage_type": "metered", "aggregate_usage": "sum"},
+				"billing_scheme": "tiered",
+				"tiers_mode": "graduated",
+				"tiers": [
+					{"up_to": 1000, "unit_amount_decimal": "0", "flat_amount": 0},
+					{"up_to": null, "unit_amount_decimal": "0.1", "flat_amount": 500}
+				]
+			}, {
+				"metadata": {"tier.feature": "feature:base@plan:pro@2"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "usage_type": "licensed"},
+				"billing_scheme": "per_unit",
+				"unit_amount_decimal": "4900"
+			}, {
+				"metadata": {"tier.feature": "feature:base@plan:yen@0"},
+				"currency": "jpy",
+				"recurring": {"interval": "month", "usage_type": "licensed"},
+				"billing_scheme": "per_unit",
+				"unit_amount_decimal": "5000"
+			}]}`)
+		default:
+			t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	got, err := tc.Quote(ctx, apitypes.QuoteRequest{
+		Features: []string{"plan:pro@2"},
+		Usage:    map[refs.Name]int{mpn("feature:api"): 1_200_000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.QuoteResponse{
+		Currency: "usd",
+		Lines: []apitypes.QuoteLine{
+			{Feature: mpf("feature:api@plan:pro@2"), Quantity: 1_200_000, Billed: 1_200_000, Amount: 120_400},
+			{Feature: mpf("feature:base@plan:pro@2"), Quantity: 1, Billed: 1, Amount: 4900},
+		},
+		Total: 125_300,
+	})
+
+	_, err = tc.Quote(ctx, apitypes.QuoteRequest{
+		Features: []string{"plan:pro@2", "plan:yen@0"},
+	})
+	diff.Test(t, t.Errorf, err, &apitypes.Error{
+		Status:  400,
+		Code:    "mixed_currencies",
+		Message: "features have different currencies",
+	})
+
+	_, err = tc.Quote(ctx, apitypes.QuoteRequest{
+		Features: []string{"plan:pro@2"},
+		Usage:    map[refs.Name]int{mpn("feature:nope"): 1},
+	})
+	diff.Test(t, t.Errorf, err, &apitypes.Error{
+		Status:  400,
+		Code:    "feature_not_found",
+		Message: "feature not found",
+	})
+}
+
+func TestDrift(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/prices"):
+			io.WriteString(w, `{"data": [{
+				"id": "price_base",
+				"active": false,
+				"lookup_key": "tier__feature-base-plan-pro-2",
+				"metadata": {"tier.feature": "feature:base@plan:pro@2", "tier.plan_title": "Pro", "tier.title": "feature:base@plan:pro@2"},
+				"product": {"id": "tier__feature-base-plan-pro-2", "name": "Pro - feature:base@plan:pro@2", "active": true}
+			}]}`)
+		case they.Want(r, "GET", "/v1/products"):
+			io.WriteString(w, `{"data": [{"id": "tier__plan-pro-2"}]}`)
+		default:
+			t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	got, err := tc.DriftJSON(ctx, []byte(`{"plans": {
+		"plan:pro@2": {"title": "Pro", "features": {"feature:base": {"base": 4900}}}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.DriftResponse{
+		Drift: []apitypes.Drift{{
+			Kind:       "inactive",
+			Plan:       mpp("plan:pro@2"),
+			Feature:    mpf("feature:base@plan:pro@2"),
+			ProviderID: "price_base",
+			Field:      "active",
+			Want:       "true",
+			Got:        "false",
+		}},
+	})
+
+	got, err = tc.DriftJSON(ctx, []byte(`{"plans": {
+		"plan:team@1": {"features": {"feature:base": {}}}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.DriftResponse{
+		Drift: []apitypes.Drift{
+			{Kind: "missing_plan", Plan: mpp("plan:team@1")},
+			{Kind: "missing_feature", Plan: mpp("plan:team@1"), Feature: mpf("feature:base@plan:team@1")},
+		},
+	})
+}
+
+func TestRevenue(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestClientWithStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/subscriptions"):
+			io.WriteString(w, `{"data": [{
+				"id": "sub_1",
+				"status": "active",
+				"metadata": {"tier.subscription": "default"},
+				"items": {"data": [{
+					"quantity": 1,
+					"price": {
+						"metadata": {"tier.feature": "feature:base@plan:pro@2"},
+						"currency": "usd",
+						"recurring": {"interval": "year", "interval_count": 1, "usage_type": "licensed"},
+						"unit_amount_decimal": "120000"
+					}
+				}, {
+					"price": {
+						"metadata": {"tier.feature": "feature:api@plan:pro@2"},
+						"currency": "usd",
+						"recurring": {"interval": "month", "interval_count": 1, "usage_type": "metered"}
+					}
+				}]}
+			}]}`)
+		case they.Want(r, "GET", "/v1/invoices"):
+			io.WriteString(w, `{"data": [
+				{"id": "in_draft", "status": "draft"},
+				{"id": "in_1", "status": "paid"}
+			]}`)
+		case they.Want(r, "GET", "/v1/invoices/in_1/lines"):
+			io.WriteString(w, `{"data": [{
+				"id": "il_1",
+				"amount": 1500,
+				"price": {
+					"metadata": {"tier.feature": "feature:api@plan:pro@2"},
+					"currency": "usd",
+					"recurring": {"interval": "month", "interval_count": 1, "usage_type": "metered"}
+				}
+			}]}`)
+		default:
+			t.Errorf("unexpected stripe request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	got, err := tc.Revenue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.RevenueResponse{
+		Plans: []apitypes.PlanRevenue{{
+			Plan:     mpp("plan:pro@2"),
+			Currency: "usd",
+			Orgs:     1,
+			Licensed: 10000,
+			Metered:  1500,
+			MRR:      11500,
+		}},
+	})
+}
@@ -13,6 +13,19 @@ type Error struct {
 	Status  int    `json:"status"`
 	Code    string `json:"code"` // (e.g. "invalid_request")
 	Message string `json:"message"`
+
+	// Problems lists the problems found in a pricing model when Code is
+	// "invalid_pricing".
+	Problems []PricingProblem `json:"problems,omitempty"`
+}
+
+// A PricingProblem is a problem found in a pricing model. Line and Column
+// are zero if the position of the problem is unknown.
+type PricingProblem struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
 }
 
 func (e *Error) Error() string {
@@ -151,6 +164,29 @@ type ReportRequest struct {
 	N       int       `json:"n"`
 	At      time.Time `json:"at"`
 	Clobber bool      `json:"clobber"`
+
+	// IdempotencyKey optionally identifies the usage event. Reports with
+	// the same org, feature, and key are only counted once, making it
+	// safe to retry failed reports. It may also be set using the
+	// Idempotency-Key header.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+type ConsumeRequest struct {
+	Org     string    `json:"org"`
+	Feature refs.Name `json:"feature"`
+	N       int       `json:"n"`
+}
+
+// ConsumeResponse reports the outcome of a ConsumeRequest. If OK is false, no
+// usage was reported.
+type ConsumeResponse struct {
+	Org       string    `json:"org"`
+	Feature   refs.Name `json:"feature"`
+	OK        bool      `json:"ok"`
+	Used      int       `json:"used"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
 }
 
 type WhoIsResponse struct {
@@ -184,6 +220,50 @@ type PushResponse struct {
 	Results []PushResult `json:"results,omitempty"`
 }
 
+type Drift struct {
+	Kind       string           `json:"kind"`
+	Plan       refs.Plan        `json:"plan"`
+	Feature    refs.FeaturePlan `json:"feature"`
+	ProviderID string           `json:"provider_id,omitempty"`
+	Field      string           `json:"field,omitempty"`
+	Want       string           `json:"want,omitempty"`
+	Got        string           `json:"got,omitempty"`
+}
+
+func (d Drift) MarshalJSON() ([]byte, error) {
+	type Alias Drift
+	return json.Marshal(&struct {
+		*Alias
+		Plan    any `json:"plan,omitempty"`
+		Feature any `json:"feature,omitempty"`
+	}{
+		Alias:   (*Alias)(&d),
+		Plan:    nilIfZero(d.Plan),
+		Feature: nilIfZero(d.Feature),
+	})
+}
+
+type DriftResponse struct {
+	Drift []Drift `json:"drift"`
+}
+
+type PlanRevenue struct {
+	Plan     refs.Plan `json:"plan"`
+	Currency string    `json:"currency"`
+	Orgs     int       `json:"orgs"`
+	Licensed int       `json:"licensed"` // in the currency's smallest unit
+	Metered  int       `json:"metered"`  // in the currency's smallest unit
+	MRR      int       `json:"mrr"`      // in the currency's smallest unit
+}
+
+type RevenueResponse struct {
+	Plans []PlanRevenue `json:"plans"`
+}
+
+type RefreshResponse struct {
+	Features int `json:"features"` // the number of features in the refreshed model
+}
+
 type WhoAmIResponse struct {
 	ProviderID string    `json:"id"`
 	Email      string    `json:"email"`
@@ -193,6 +273,26 @@ type WhoAmIResponse struct {
 	URL        string    `json:"url"`
 }
 
+// QuoteRequest requests the cost of plans and features for an amount of
+// usage over one billing period.
+type QuoteRequest struct {
+	Features []string          `json:"features"` // plans and feature plans
+	Usage    map[refs.Name]int `json:"usage,omitempty"`
+}
+
+type QuoteLine struct {
+	Feature  refs.FeaturePlan `json:"feature"`
+	Quantity int              `json:"quantity"`
+	Billed   int              `json:"billed"` // quantity after transforms
+	Amount   int              `json:"amount"` // in the currency's smallest unit
+}
+
+type QuoteResponse struct {
+	Currency string      `json:"currency"`
+	Lines    []QuoteLine `json:"lines"`
+	Total    int         `json:"total"` // in the currency's smallest unit
+}
+
 type ClockRequest struct {
 	ID      string
 	Name    string
@@ -201,11 +301,16 @@ type ClockRequest struct {
 
 type ClockResponse struct {
 	ID      string    `json:"id"`
+	Name    string    `json:"name"`
 	Link    string    `json:"link"`
 	Present time.Time `json:"present"`
 	Status  string    `json:"status"`
 }
 
+type ClocksResponse struct {
+	Clocks []ClockResponse `json:"clocks"`
+}
+
 func nilIfZero[T comparable](v T) any {
 	if z, ok := any(v).(interface{ IsZero() bool }); ok && z.IsZero() {
 		return nil
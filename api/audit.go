@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"tier.run/api/apitypes"
+	"tier.run/audit"
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/stripe"
+	"tier.run/trweb"
+)
+
+// isAudited reports if requests with method to path change state in Stripe
+// and are recorded in the audit log.
+func isAudited(method, path string) bool {
+	switch path {
+	case "/v1/subscribe", "/v1/checkout", "/v1/push", "/v1/report":
+		return method == "POST"
+	case "/v1/clock":
+		return method == "POST" || method == "DELETE"
+	}
+	return false
+}
+
+// maxAuditedBodyBytes is the largest request body buffered to be audited.
+// Pricing models and other audited requests are far smaller.
+const maxAuditedBodyBytes = 4 << 20
+
+var errBodyTooLarge = trweb.Error(413, "request_too_large", "request body too large")
+
+type auditKey struct{}
+
+// auditRecord holds the audit entry of a request while it is served.
+type auditRecord struct {
+	e   *audit.Entry // nil if the request is not audited
+	ids *stripe.RequestIDs
+}
+
+// withAuditRecord returns r with rec in its context, for startAudit to fill
+// in if r is audited.
+func withAuditRecord(r *http.Request, rec *auditRecord) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), auditKey{}, rec))
+}
+
+// startAudit prepares r to be recorded in h.Audit once served. It must only
+// be called for authenticated requests for which isAudited reports true. It
+// returns the request to serve in place of r. Requests not worth recording,
+// such as reports that only add usage, are not recorded.
+func (h *Handler) startAudit(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	rec, _ := r.Context().Value(auditKey{}).(*auditRecord)
+	if rec == nil {
+		return r, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuditedBodyBytes))
+	if err != nil {
+		var me *http.MaxBytesError
+		if errors.As(err, &me) {
+			return nil, errBodyTooLarge
+		}
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	org, summary, ok := summarizeRequest(r, body)
+	if !ok {
+		return r, nil
+	}
+
+	ctx, ids := stripe.WithRequestIDs(r.Context())
+	rec.ids = ids
+	rec.e = &audit.Entry{
+		Time:    time.Now(),
+		Caller:  r.Header.Get(tier.CallerHeader),
+		Token:   tokenFingerprint(requestToken(r)),
+		Account: r.Header.Get(tier.AccountHeader),
+		Clock:   r.Header.Get(tier.ClockHeader),
+		Method:  r.Method,
+		Route:   r.URL.Path,
+		Org:     org,
+		Summary: summary,
+	}
+	return r.WithContext(ctx), nil
+}
+
+// setAuditSummary sets the summary of the audit entry of the request with
+// ctx, if it is audited, for handlers that describe the change once they
+// have decoded the request.
+func setAuditSummary(ctx context.Context, summary string) {
+	if rec, _ := ctx.Value(auditKey{}).(*auditRecord); rec != nil && rec.e != nil {
+		rec.e.Summary = summary
+	}
+}
+
+// finishAudit records the audit entry in rec, if any, with the outcome of
+// the request.
+func (h *Handler) finishAudit(rec *auditRecord, status int, err error) {
+	if rec.e == nil {
+		return
+	}
+	e := *rec.e
+	e.Status = status
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.StripeRequestIDs = rec.ids.List()
+	if err := h.Audit.Record(e); err != nil {
+		h.Logf("audit: %v", err)
+	}
+}
+
+// tokenFingerprint returns a short hash of tok that identifies it without
+// revealing it, or the empty string if tok is empty.
+func tokenFingerprint(tok string) string {
+	if tok == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:6])
+}
+
+// summarizeRequest returns the org r is for, if any, and a short
+// description of the change it requests. It reports false if r does not
+// need to be audited. Requests with bodies that cannot be decoded are
+// audited with an empty summary; they fail when served. Pushes are
+// summarized by servePush, so that pricing models are parsed only once.
+func summarizeRequest(r *http.Request, body []byte) (org, summary string, ok bool) {
+	switch r.URL.Path {
+	case "/v1/subscribe":
+		var sr apitypes.ScheduleRequest
+		if json.Unmarshal(body, &sr) != nil {
+			return "", "", true
+		}
+		var parts []string
+		for _, p := range sr.Phases {
+			s := "cancel"
+			if len(p.Features) > 0 {
+				s = strings.Join(p.Features, ", ")
+			}
+			if p.Trial {
+				s += " (trial)"
+			}
+			if !p.Effective.IsZero() {
+				s += " from " + p.Effective.Format(time.RFC3339)
+			}
+			parts = append(parts, "phase: "+s)
+		}
+		if sr.Info != nil {
+			parts = append(parts, "info updated")
+		}
+		if sr.PaymentMethodID != "" {
+			parts = append(parts, "payment method: "+sr.PaymentMethodID)
+		}
+		return sr.Org, strings.Join(parts, "; "), true
+	case "/v1/checkout":
+		var cr apitypes.CheckoutRequest
+		if json.Unmarshal(body, &cr) != nil {
+			return "", "", true
+		}
+		if len(cr.Features) == 0 {
+			return cr.Org, "setup payment method", true
+		}
+		s := "features: " + strings.Join(cr.Features, ", ")
+		if cr.TrialDays > 0 {
+			s += fmt.Sprintf("; trial days: %d", cr.TrialDays)
+		}
+		return cr.Org, s, true
+	case "/v1/report":
+		var rr apitypes.ReportRequest
+		if json.Unmarshal(body, &rr) != nil {
+			return "", "", false
+		}
+		if !rr.Clobber {
+			return "", "", false
+		}
+		return rr.Org, fmt.Sprintf("set %s usage to %d (clobber)", rr.Feature, rr.N), true
+	case "/v1/push":
+		return "", "", true
+	case "/v1/clock":
+		if r.Method == "DELETE" {
+			return "", "delete " + r.URL.Query().Get("id"), true
+		}
+		var cr apitypes.ClockRequest
+		if json.Unmarshal(body, &cr) != nil {
+			return "", "", true
+		}
+		if cr.ID == "" {
+			return "", fmt.Sprintf("create %q at %s", cr.Name, cr.Present.Format(time.RFC3339)), true
+		}
+		return "", fmt.Sprintf("advance %s to %s", cr.ID, cr.Present.Format(time.RFC3339)), true
+	}
+	return "", "", false
+}
+
+// summarizePush returns the audit summary of a push of fs.
+func summarizePush(fs []control.Feature) string {
+	var plans []string
+	for _, f := range fs {
+		if p := f.Plan().String(); !slices.Contains(plans, p) {
+			plans = append(plans, p)
+		}
+	}
+	slices.Sort(plans)
+	return "plans: " + strings.Join(plans, ", ")
+}
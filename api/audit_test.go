@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/audit"
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/stripe"
+	"tier.run/types/they"
+)
+
+type auditLog struct {
+	mu sync.Mutex
+	es []audit.Entry
+}
+
+func (l *auditLog) Record(e audit.Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.es = append(l.es, e)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	var n atomic.Int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Request-Id", fmt.Sprintf("req_%d", n.Add(1)))
+		switch {
+		case they.Want(r, "GET", "/v1/customers(/search)?"):
+			io.WriteString(w, `{"data": []}`)
+		case r.Method == "POST":
+			w.WriteHeader(400)
+			io.WriteString(w, `{"error": {"type": "invalid_request_error"}}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	cc := &control.Client{
+		Stripe: &stripe.Client{
+			BaseURL:    s.URL,
+			HTTPClient: s.Client(),
+			Logf:       t.Logf,
+		},
+		Logf: t.Logf,
+	}
+	var log auditLog
+	h := NewHandler(cc, t.Logf)
+	h.Auth = &Auth{Tokens: []string{"secret"}}
+	h.Audit = &log
+	hs := httptest.NewServer(h)
+	t.Cleanup(hs.Close)
+	tc := &tier.Client{
+		BaseURL:    hs.URL,
+		HTTPClient: hs.Client(),
+		Token:      "secret",
+		Caller:     "billing-worker",
+		Logf:       t.Logf,
+	}
+	ctx := context.Background()
+
+	if _, err := tc.PushJSON(ctx, []byte(`{"plans": {
+		"plan:b@1": {"features": {"feature:x": {}}},
+		"plan:a@1": {"features": {"feature:x": {}, "feature:y": {}}}
+	}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// plain usage reports are not audited
+	if err := tc.Report(ctx, "org:acme", "feature:x", 1); !isAPIErrorCode(err, "org_not_found") {
+		t.Fatalf("err = %v; want org_not_found", err)
+	}
+	err := tc.ReportUsage(ctx, "org:acme", "feature:x", 10, &tier.ReportParams{Clobber: true})
+	if !isAPIErrorCode(err, "org_not_found") {
+		t.Fatalf("err = %v; want org_not_found", err)
+	}
+
+	if len(log.es) != 2 {
+		t.Fatalf("got %d entries; want 2", len(log.es))
+	}
+	if n := len(log.es[0].StripeRequestIDs); n == 0 {
+		t.Error("no Stripe request IDs recorded for push")
+	}
+	if !strings.Contains(log.es[1].Error, "org not found") {
+		t.Errorf("Error = %q; want org not found", log.es[1].Error)
+	}
+	fp := tokenFingerprint("secret")
+	diff.Test(t, t.Errorf, log.es, []audit.Entry{{
+		Caller:  "billing-worker",
+		Token:   fp,
+		Method:  "POST",
+		Route:   "/v1/push",
+		Summary: "plans: plan:a@1, plan:b@1",
+		Status:  200,
+	}, {
+		Caller:  "billing-worker",
+		Token:   fp,
+		Method:  "POST",
+		Route:   "/v1/report",
+		Org:     "org:acme",
+		Summary: "set feature:x usage to 10 (clobber)",
+		Status:  400,
+	}}, diff.ZeroFields[audit.Entry]("Time", "StripeRequestIDs", "Error"))
+	if n := len(log.es[1].StripeRequestIDs); n != 2 {
+		t.Errorf("got %d Stripe request IDs for report; want 2 (search and list)", n)
+	}
+}
+
+// unreadBody is a request body that fails the test if read.
+type unreadBody struct{ t *testing.T }
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Error("request body read")
+	return 0, io.EOF
+}
+
+func TestAuditUnauthenticated(t *testing.T) {
+	var log auditLog
+	h := NewHandler(&control.Client{Stripe: &stripe.Client{}, Logf: t.Logf}, t.Logf)
+	h.Auth = &Auth{Tokens: []string{"secret"}}
+	h.Accounts = []string{"acct_allowed"}
+	h.Audit = &log
+
+	for _, account := range []string{"", "acct_other"} {
+		r := httptest.NewRequest("POST", "/v1/push", unreadBody{t})
+		if account != "" {
+			r.Header.Set("Authorization", "Bearer secret")
+			r.Header.Set(tier.AccountHeader, account)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != 401 && w.Code != 403 {
+			t.Errorf("account %q: status = %d; want 401 or 403", account, w.Code)
+		}
+	}
+	if len(log.es) != 0 {
+		t.Errorf("got %d entries; want 0", len(log.es))
+	}
+
+	// audited bodies are limited in size
+	r := httptest.NewRequest("POST", "/v1/push", strings.NewReader(strings.Repeat(" ", maxAuditedBodyBytes+1)))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 413 {
+		t.Errorf("status = %d; want 413", w.Code)
+	}
+}
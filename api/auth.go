@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/trweb"
+)
+
+// readOnlyRoutes are the routes that may be used with a read-only token.
+var readOnlyRoutes = []string{
+	"/v1/limits",
+	"/v1/phase",
+	"/v1/whois",
+}
+
+// Auth holds the bearer tokens accepted by a Handler. More than one token
+// of each kind may be configured at a time so that tokens can be rotated
+// without downtime: add the new token, update clients, then remove the old
+// token.
+type Auth struct {
+	Tokens         []string // tokens granting access to all routes
+	ReadOnlyTokens []string // tokens granting access to read-only routes only
+}
+
+// Empty reports if a has no tokens configured.
+func (a *Auth) Empty() bool {
+	return len(a.Tokens) == 0 && len(a.ReadOnlyTokens) == 0
+}
+
+// check reports an error if r does not carry a token permitted to use the
+// route in r.
+func (a *Auth) check(r *http.Request) error {
+	tok := requestToken(r)
+	if tok == "" {
+		return trweb.Unauthorized
+	}
+	if containsToken(a.Tokens, tok) {
+		return nil
+	}
+	if containsToken(a.ReadOnlyTokens, tok) {
+		if slices.Contains(readOnlyRoutes, r.URL.Path) {
+			return nil
+		}
+		return trweb.Forbidden
+	}
+	return trweb.Unauthorized
+}
+
+// requestToken returns the bearer token in r. For compatibility with clients
+// that send their key using basic auth, the basic auth username is used if
+// no bearer token is present.
+func requestToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > len("Bearer ") && strings.EqualFold(h[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(h[len("Bearer "):])
+	}
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+func containsToken(tokens []string, tok string) bool {
+	var found int
+	for _, t := range tokens {
+		// check all tokens to avoid leaking which matched via timing
+		found |= subtle.ConstantTimeCompare([]byte(t), []byte(tok))
+	}
+	return found == 1
+}
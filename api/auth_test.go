@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tier.run/trweb"
+)
+
+func TestAuthCheck(t *testing.T) {
+	a := &Auth{
+		Tokens:         []string{"old", "new"},
+		ReadOnlyTokens: []string{"ro"},
+	}
+
+	cases := []struct {
+		path   string
+		header string
+		basic  string
+		want   error
+	}{
+		{"/v1/report", "", "", trweb.Unauthorized},
+		{"/v1/report", "Bearer nope", "", trweb.Unauthorized},
+		{"/v1/report", "Bearer old", "", nil},
+		{"/v1/report", "Bearer new", "", nil},
+		{"/v1/report", "bearer new", "", nil},
+		{"/v1/report", "", "new", nil},
+		{"/v1/report", "Bearer ro", "", trweb.Forbidden},
+		{"/v1/push", "Bearer ro", "", trweb.Forbidden},
+		{"/v1/limits", "Bearer ro", "", nil},
+		{"/v1/phase", "Bearer ro", "", nil},
+		{"/v1/whois", "Bearer ro", "", nil},
+	}
+	for _, tt := range cases {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if tt.basic != "" {
+			r.SetBasicAuth(tt.basic, "")
+		}
+		if got := a.check(r); got != tt.want {
+			t.Errorf("check(%s, %q, %q) = %v; want %v", tt.path, tt.header, tt.basic, got, tt.want)
+		}
+	}
+}
+
+func TestAuthHandler(t *testing.T) {
+	h := NewHandler(nil, t.Logf)
+	h.Auth = &Auth{Tokens: []string{"secret"}}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/whoami", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"tier.run/trweb"
+)
+
+// Readiness check results are cached so that frequent probes by an
+// orchestrator do not count against the Stripe rate limit. Failures are
+// cached for less time than successes so that a recovered sidecar is marked
+// ready again quickly.
+const (
+	readyTTL        = 30 * time.Second
+	notReadyTTL     = 5 * time.Second
+	readyCheckLimit = 5 * time.Second
+)
+
+var errNotReady = trweb.Error(503, "not_ready", "Stripe is unreachable or the API key is invalid")
+
+// readyCache holds the result of the last readiness check.
+type readyCache struct {
+	mu      sync.Mutex // held during checks so concurrent probes share one
+	checked time.Time
+	err     error
+}
+
+// serveHealthz reports that the process is alive. It does not contact
+// Stripe.
+func (h *Handler) serveHealthz(w http.ResponseWriter, r *http.Request) error {
+	return httpJSON(w, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// serveReadyz reports if the sidecar is ready to serve requests, which is
+// when Stripe is reachable and accepts the configured API key.
+func (h *Handler) serveReadyz(w http.ResponseWriter, r *http.Request) error {
+	if err := h.checkReady(r.Context()); err != nil {
+		h.Logf("readyz: %v", err)
+		return errNotReady
+	}
+	return httpJSON(w, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+func (h *Handler) checkReady(ctx context.Context) error {
+	rc := &h.ready
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	ttl := readyTTL
+	if rc.err != nil {
+		ttl = notReadyTTL
+	}
+	if !rc.checked.IsZero() && time.Since(rc.checked) < ttl {
+		return rc.err
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, readyCheckLimit)
+	defer cancel()
+	_, err := h.c.WhoAmI(cctx)
+	if ctx.Err() != nil {
+		// the probe went away; do not cache its result
+		return ctx.Err()
+	}
+	rc.checked = time.Now()
+	rc.err = err
+	return err
+}
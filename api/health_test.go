@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tier.run/control"
+	"tier.run/stripe"
+)
+
+func TestHealthz(t *testing.T) {
+	h := NewHandler(nil, t.Logf)
+	h.Auth = &Auth{Tokens: []string{"secret"}}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != 200 {
+		t.Errorf("status = %d; want 200", w.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	var calls atomic.Int32
+	var valid atomic.Bool
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !valid.Load() {
+			w.WriteHeader(401)
+			w.Write([]byte(`{"error": {"type": "invalid_request_error", "message": "Invalid API Key provided"}}`))
+			return
+		}
+		w.Write([]byte(`{"id": "acct_123"}`))
+	}))
+	defer s.Close()
+
+	cc := &control.Client{
+		Stripe: &stripe.Client{
+			BaseURL:    s.URL,
+			HTTPClient: s.Client(),
+			Logf:       t.Logf,
+		},
+		Logf: t.Logf,
+	}
+	h := NewHandler(cc, t.Logf)
+	h.Auth = &Auth{Tokens: []string{"secret"}}
+
+	check := func(want, wantCalls int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != want {
+			t.Errorf("status = %d; want %d", w.Code, want)
+		}
+		if got := int(calls.Load()); got != wantCalls {
+			t.Errorf("calls = %d; want %d", got, wantCalls)
+		}
+	}
+
+	check(503, 1)
+	check(503, 1) // cached
+
+	valid.Store(true)
+	h.ready.checked = time.Now().Add(-notReadyTTL) // expire
+	check(200, 2)
+	check(200, 2) // cached
+
+	valid.Store(false)
+	h.ready.checked = time.Now().Add(-readyTTL) // expire
+	check(503, 3)
+}
@@ -1,50 +1,363 @@
 package materialize
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
-	"tailscale.com/util/multierr"
-	"tier.run/api/apitypes"
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/refs"
 )
 
-func validate(m apitypes.Model) error {
-	var e errors
-	for plan, p := range m.Plans {
-		if len(p.Features) == 0 {
-			e.reportf("plans[%q]: plans must have at least one feature", plan)
+// maxPlanFeatures is the maximum number of items Stripe allows in a
+// subscription, and so the number of features in a plan.
+const maxPlanFeatures = 20
+
+var (
+	knownIntervals  = []string{"@daily", "@weekly", "@monthly", "@yearly"}
+	knownModes      = []string{"graduated", "volume"}
+	knownAggregates = []string{"sum", "max", "last", "perpetual"}
+	knownRoundings  = []string{"up", "down"}
+)
+
+// A Problem is a problem found in a pricing model.
+type Problem struct {
+	Path    string // e.g. plans["plan:free@0"].features["feature:x"].tiers[1].upto
+	Line    int    // 1-based; zero if unknown
+	Column  int    // 1-based, in bytes; zero if unknown
+	Message string
+}
+
+func (p *Problem) Error() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "%d:%d: ", p.Line, p.Column)
+	}
+	if p.Path != "" {
+		b.WriteString(p.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// Problems is a list of problems found in a pricing model, ordered by
+// position.
+type Problems []*Problem
+
+func (ps Problems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = p.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the pricing model in data, in the format f, and reports
+// all problems found as Problems. It returns nil if data is a valid pricing
+// model.
+func Validate(data []byte, f Format) error {
+	n, err := parseTree(data, f)
+	if err != nil {
+		return err
+	}
+	var c checker
+	c.model(n)
+	if len(c.problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i], c.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
 		}
-		for feature, f := range p.Features {
-			if f.Base > 0 && len(f.Tiers) > 0 {
-				e.reportf("plans[%q].features[%q]: base must be zero with tiers", plan, feature)
-			}
-			if f.Base < 0 {
-				e.reportf("plans[%q].features[%q]: base must be positive", plan, feature)
+		return a.Column < b.Column
+	})
+	return c.problems
+}
+
+type checker struct {
+	problems Problems
+}
+
+func (c *checker) reportf(p pos, path string, format string, args ...any) {
+	c.problems = append(c.problems, &Problem{
+		Path:    path,
+		Line:    p.line,
+		Column:  p.col,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func fieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func keyPath(path, key string) string {
+	return fmt.Sprintf("%s[%q]", path, key)
+}
+
+// members returns the members of the object n, reporting if n is not an
+// object, and dropping and reporting duplicate keys. It returns nil if n is
+// null.
+func (c *checker) members(n *node, path string) []member {
+	if n.kind == kindNull {
+		return nil
+	}
+	if n.kind != kindObject {
+		c.reportf(n.pos, path, "must be an object, not %s", n.kind)
+		return nil
+	}
+	var ms []member
+	seen := map[string]bool{}
+	for _, m := range n.members {
+		if seen[m.key] {
+			c.reportf(m.keyPos, path, "duplicate key %q", m.key)
+			continue
+		}
+		seen[m.key] = true
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+func (c *checker) elems(n *node, path string) []*node {
+	if n.kind == kindNull {
+		return nil
+	}
+	if n.kind != kindArray {
+		c.reportf(n.pos, path, "must be an array, not %s", n.kind)
+		return nil
+	}
+	return n.elems
+}
+
+func (c *checker) unknown(m member, path string) {
+	c.reportf(m.keyPos, path, "unknown field %q", m.key)
+}
+
+func (c *checker) str(n *node, path string) (string, bool) {
+	switch n.kind {
+	case kindNull:
+		return "", false
+	case kindString:
+		return n.str, true
+	}
+	c.reportf(n.pos, path, "must be a string, not %s", n.kind)
+	return "", false
+}
+
+func (c *checker) number(n *node, path string) (float64, bool) {
+	switch n.kind {
+	case kindNull:
+		return 0, false
+	case kindNumber:
+		return n.num, true
+	}
+	c.reportf(n.pos, path, "must be a number, not %s", n.kind)
+	return 0, false
+}
+
+func (c *checker) integer(n *node, path string) (int, bool) {
+	v, ok := c.number(n, path)
+	if !ok {
+		return 0, false
+	}
+	if !n.isInt {
+		c.reportf(n.pos, path, "must be an integer")
+		return 0, false
+	}
+	return int(v), true
+}
+
+// oneOf reports if n is not empty or one of the known values.
+func (c *checker) oneOf(n *node, path string, known []string) {
+	s, ok := c.str(n, path)
+	if ok && s != "" && !slices.Contains(known, s) {
+		c.reportf(n.pos, path, "unknown value %q; must be one of %s", s, strings.Join(known, ", "))
+	}
+}
+
+func (c *checker) model(n *node) {
+	for _, m := range c.members(n, "") {
+		p := fieldPath("", m.key)
+		switch m.key {
+		case "plans":
+			c.plans(m.val, p)
+		default:
+			c.unknown(m, p)
+		}
+	}
+}
+
+func (c *checker) plans(n *node, path string) {
+	for _, m := range c.members(n, path) {
+		p := keyPath(path, m.key)
+		if _, err := refs.ParsePlan(m.key); err != nil {
+			c.reportf(m.keyPos, p, "%s", parseErrorMessage(err))
+		}
+		c.plan(m, p)
+	}
+}
+
+func parseErrorMessage(err error) string {
+	var pe *refs.ParseError
+	if errors.As(err, &pe) {
+		return pe.Message
+	}
+	return err.Error()
+}
+
+func (c *checker) plan(pm member, path string) {
+	var numFeatures int
+	var features *node
+	for _, m := range c.members(pm.val, path) {
+		p := fieldPath(path, m.key)
+		switch m.key {
+		case "title":
+			c.str(m.val, p)
+		case "interval":
+			c.oneOf(m.val, p, knownIntervals)
+		case "currency":
+			s, ok := c.str(m.val, p)
+			if ok && s != "" && !slices.Contains(knownCurrencies, s) {
+				c.reportf(m.val.pos, p, "unknown currency %q; must be a lowercase ISO 4217 code supported by Stripe", s)
 			}
+		case "features":
+			features = m.val
+			numFeatures = c.features(m.val, p)
+		default:
+			c.unknown(m, p)
+		}
+	}
+	if numFeatures == 0 {
+		c.reportf(pm.keyPos, path, "plans must have at least one feature")
+	}
+	if numFeatures > maxPlanFeatures {
+		c.reportf(features.pos, fieldPath(path, "features"),
+			"plans must have at most %d features; Stripe allows at most %d items per subscription",
+			maxPlanFeatures, maxPlanFeatures)
+	}
+}
 
-			for i, t := range f.Tiers {
-				if t.Upto < 1 {
-					e.reportf("plans[%q].features[%q].tiers[%d]: upto must be greater than zero", plan, feature, i)
-				}
-				if t.Price < 0 {
-					e.reportf("plans[%q].features[%q].tiers[%d]: price must be positive", plan, feature, i)
+// features checks the features of a plan and returns the number found.
+func (c *checker) features(n *node, path string) int {
+	ms := c.members(n, path)
+	for _, m := range ms {
+		p := keyPath(path, m.key)
+		if _, err := refs.ParseName(m.key); err != nil {
+			c.reportf(m.keyPos, p, "%s", parseErrorMessage(err))
+		}
+		c.feature(m.val, p)
+	}
+	return len(ms)
+}
+
+func (c *checker) feature(n *node, path string) {
+	var base *node
+	var numTiers int
+	for _, m := range c.members(n, path) {
+		p := fieldPath(path, m.key)
+		switch m.key {
+		case "title":
+			c.str(m.val, p)
+		case "base":
+			if v, ok := c.number(m.val, p); ok {
+				if v < 0 {
+					c.reportf(m.val.pos, p, "base must be positive")
 				}
-				if t.Base < 0 {
-					e.reportf("plans[%q].features[%q].tiers[%d]: base must be positive", plan, feature, i)
+				if v > 0 {
+					base = m.val
 				}
 			}
+		case "mode":
+			c.oneOf(m.val, p, knownModes)
+		case "aggregate":
+			c.oneOf(m.val, p, knownAggregates)
+		case "tiers":
+			numTiers = c.tiers(m.val, p)
+		case "divide":
+			c.divide(m.val, p)
+		default:
+			c.unknown(m, p)
 		}
 	}
-	return multierr.New(e...)
+	if base != nil && numTiers > 0 {
+		c.reportf(base.pos, fieldPath(path, "base"), "base must be zero with tiers")
+	}
 }
 
-type errors []error
+// tiers checks the tiers of a feature and returns the number found.
+func (c *checker) tiers(n *node, path string) int {
+	es := c.elems(n, path)
+	prev := 0
+	for i, e := range es {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		var upto *node
+		for _, m := range c.members(e, p) {
+			mp := fieldPath(p, m.key)
+			switch m.key {
+			case "upto":
+				upto = m.val
+			case "price":
+				if v, ok := c.number(m.val, mp); ok && v < 0 {
+					c.reportf(m.val.pos, mp, "price must be positive")
+				}
+			case "base":
+				if v, ok := c.integer(m.val, mp); ok && v < 0 {
+					c.reportf(m.val.pos, mp, "base must be positive")
+				}
+			default:
+				c.unknown(m, mp)
+			}
+		}
 
-func (e *errors) report(err error) {
-	if err != nil {
-		*e = append(*e, err)
+		last := i == len(es)-1
+		if upto == nil || upto.kind == kindNull {
+			if !last {
+				c.reportf(e.pos, p, "only the last tier may omit upto")
+			}
+			continue
+		}
+		mp := fieldPath(p, "upto")
+		v, ok := c.integer(upto, mp)
+		switch {
+		case !ok:
+		case v < 1:
+			c.reportf(upto.pos, mp, "upto must be greater than zero")
+		case v <= prev:
+			c.reportf(upto.pos, mp, "upto must be greater than the upto of the previous tier (%d)", prev)
+		default:
+			prev = v
+		}
 	}
+	return len(es)
 }
 
-func (e *errors) reportf(format string, args ...any) {
-	e.report(fmt.Errorf(format, args...))
+func (c *checker) divide(n *node, path string) {
+	if n.kind == kindNull {
+		return
+	}
+	hasBy := false
+	for _, m := range c.members(n, path) {
+		p := fieldPath(path, m.key)
+		switch m.key {
+		case "by":
+			hasBy = true
+			if v, ok := c.integer(m.val, p); ok && v < 1 {
+				c.reportf(m.val.pos, p, "by must be greater than zero")
+			}
+		case "rounding":
+			c.oneOf(m.val, p, knownRoundings)
+		default:
+			c.unknown(m, p)
+		}
+	}
+	if !hasBy && n.kind == kindObject {
+		c.reportf(n.pos, path, "by must be greater than zero")
+	}
 }
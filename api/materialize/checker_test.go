@@ -1,9 +1,12 @@
 package materialize
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
-	"tier.run/api/apitypes"
+	"kr.dev/diff"
 	"tier.run/refs"
 )
 
@@ -22,16 +25,8 @@ func TestValidatePlanID(t *testing.T) {
 	for _, tc := range cases {
 		planID := refs.MustParsePlan(tc.planID)
 		t.Run(tc.planID, func(t *testing.T) {
-			m := apitypes.Model{
-				Plans: map[refs.Plan]apitypes.Plan{
-					planID: {
-						Features: map[refs.Name]apitypes.Feature{
-							refs.MustParseName("feature:x"): {},
-						},
-					},
-				},
-			}
-			err := validate(m)
+			data := fmt.Sprintf(`{"plans": {%q: {"features": {"feature:x": {}}}}}`, planID)
+			err := Validate([]byte(data), FormatJSON)
 			if tc.valid && err != nil {
 				t.Errorf("unexpected error: %v", err)
 			}
@@ -41,3 +36,131 @@ func TestValidatePlanID(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateProblems(t *testing.T) {
+	data := `{
+	"plans": {
+		"plan:free@0": {
+			"currency": "usd",
+			"interval": "@hourly",
+			"features": {
+				"feature:api": {
+					"mode": "stacked",
+					"aggregate": "sum",
+					"tiers": [
+						{"upto": 100, "price": 1},
+						{"price": 2},
+						{"upto": 50, "price": -1},
+						{"upto": 10.5}
+					]
+				},
+				"feature:seats": {
+					"base": 10,
+					"tiers": [{"upto": 1}],
+					"divide": {"by": 0, "rounding": "sideways"},
+					"titel": "Seats"
+				},
+				"bad": {}
+			}
+		},
+		"plan:pro": {
+			"currency": "xyz",
+			"features": {}
+		}
+	}
+}`
+	err := Validate([]byte(data), FormatJSON)
+	var ps Problems
+	if !errors.As(err, &ps) {
+		t.Fatalf("err = %v; want Problems", err)
+	}
+	var got []string
+	for _, p := range ps {
+		got = append(got, p.Error())
+	}
+	want := []string{
+		`5:16: plans["plan:free@0"].interval: unknown value "@hourly"; must be one of @daily, @weekly, @monthly, @yearly`,
+		`8:14: plans["plan:free@0"].features["feature:api"].mode: unknown value "stacked"; must be one of graduated, volume`,
+		`12:7: plans["plan:free@0"].features["feature:api"].tiers[1]: only the last tier may omit upto`,
+		`13:16: plans["plan:free@0"].features["feature:api"].tiers[2].upto: upto must be greater than the upto of the previous tier (100)`,
+		`13:29: plans["plan:free@0"].features["feature:api"].tiers[2].price: price must be positive`,
+		`14:16: plans["plan:free@0"].features["feature:api"].tiers[3].upto: must be an integer`,
+		`18:14: plans["plan:free@0"].features["feature:seats"].base: base must be zero with tiers`,
+		`20:23: plans["plan:free@0"].features["feature:seats"].divide.by: by must be greater than zero`,
+		`20:38: plans["plan:free@0"].features["feature:seats"].divide.rounding: unknown value "sideways"; must be one of up, down`,
+		`21:6: plans["plan:free@0"].features["feature:seats"].titel: unknown field "titel"`,
+		`23:5: plans["plan:free@0"].features["bad"]: feature name must start with 'feature:'`,
+		`26:3: plans["plan:pro"]: plan must have version`,
+		`26:3: plans["plan:pro"]: plans must have at least one feature`,
+		`27:16: plans["plan:pro"].currency: unknown currency "xyz"; must be a lowercase ISO 4217 code supported by Stripe`,
+	}
+	diff.Test(t, t.Errorf, got, want)
+}
+
+func TestValidateSyntax(t *testing.T) {
+	cases := []struct {
+		format Format
+		data   string
+		want   string
+	}{
+		{FormatJSON, "{\n\t\"plans\": {,}\n}", "2:12: "},
+		{FormatYAML, "plans:\n  plan:free@0: [\n", "2:1: "},
+		{FormatTOML, "[plans]\nx = \n", "2:5: "},
+	}
+	for _, tt := range cases {
+		err := Validate([]byte(tt.data), tt.format)
+		var ps Problems
+		if !errors.As(err, &ps) || len(ps) != 1 {
+			t.Errorf("%s: err = %v; want one problem", tt.format, err)
+			continue
+		}
+		if got := ps[0].Error(); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: err = %q; want prefix %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestValidateYAMLPositions(t *testing.T) {
+	data := `plans:
+  plan:free@0:
+    features: &f
+      feature:x:
+        base: -1
+  plan:pro@0:
+    features:
+      <<: *f
+      feature:y:
+        mode: stacked
+`
+	err := Validate([]byte(data), FormatYAML)
+	var ps Problems
+	if !errors.As(err, &ps) {
+		t.Fatalf("err = %v; want Problems", err)
+	}
+	var got []string
+	for _, p := range ps {
+		got = append(got, p.Error())
+	}
+	want := []string{
+		`5:15: plans["plan:free@0"].features["feature:x"].base: base must be positive`,
+		`5:15: plans["plan:pro@0"].features["feature:x"].base: base must be positive`,
+		`10:15: plans["plan:pro@0"].features["feature:y"].mode: unknown value "stacked"; must be one of graduated, volume`,
+	}
+	diff.Test(t, t.Errorf, got, want)
+}
+
+func TestValidateTooManyFeatures(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`{"plans": {"plan:big@0": {"features": {`)
+	for i := 0; i <= maxPlanFeatures; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `"feature:f%d": {}`, i)
+	}
+	b.WriteString(`}}}}`)
+	err := Validate([]byte(b.String()), FormatJSON)
+	if err == nil || !strings.Contains(err.Error(), "at most 20 features") {
+		t.Errorf("err = %v; want too many features", err)
+	}
+}
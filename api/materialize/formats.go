@@ -0,0 +1,192 @@
+package materialize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// A Format is an encoding of a pricing model.
+type Format string
+
+// Known formats.
+const (
+	FormatJSON Format = "json" // JSON, or HuJSON when decoding
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// ParseFormat parses the format name s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatJSON, FormatYAML, FormatTOML:
+		return f, nil
+	case "yml":
+		return FormatYAML, nil
+	}
+	return "", fmt.Errorf("unknown pricing format %q; must be json, yaml, or toml", s)
+}
+
+// FormatFromName returns the format of the file or URL path name based on
+// its extension. Names with unknown or no extensions are assumed to be JSON.
+func FormatFromName(name string) Format {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// FormatFromContentType returns the format for the media type in the
+// Content-Type header value ct. Unknown or missing media types are assumed to
+// be JSON.
+func FormatFromContentType(ct string) Format {
+	mt, _, _ := mime.ParseMediaType(ct)
+	switch mt {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return FormatYAML
+	case "application/toml", "text/toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// toJSON converts data in the format f to JSON.
+func toJSON(data []byte, f Format) ([]byte, error) {
+	var v any
+	switch f {
+	case FormatJSON, "":
+		return data, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		var err error
+		if v, err = stringKeys(v); err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = map[string]any{} // empty document
+		}
+	case FormatTOML:
+		m := map[string]any{}
+		if err := toml.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		v = m
+	default:
+		return nil, fmt.Errorf("unknown pricing format %q", f)
+	}
+	return json.Marshal(v)
+}
+
+// stringKeys returns v with all maps having non-string keys converted to
+// maps with string keys, as required by encoding/json. It reports an error
+// if a key is not a string.
+func stringKeys(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			e, err := stringKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+		return v, nil
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v: keys must be strings", k)
+			}
+			e, err := stringKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			m[ks] = e
+		}
+		return m, nil
+	case []any:
+		for i, e := range v {
+			e, err := stringKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// ConvertPricingJSON converts the pricing JSON in data, as produced by
+// ToPricingJSON, to the format f.
+func ConvertPricingJSON(data []byte, f Format) ([]byte, error) {
+	if f == FormatJSON {
+		return data, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	v = fromNumbers(v)
+
+	switch f {
+	case FormatYAML:
+		var b bytes.Buffer
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case FormatTOML:
+		var b bytes.Buffer
+		enc := toml.NewEncoder(&b)
+		enc.Indent = ""
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown pricing format %q", f)
+}
+
+// fromNumbers replaces each json.Number in v with an int64 if it is an
+// integer, or a float64 otherwise, so that integers are encoded as integers
+// in formats that distinguish them.
+func fromNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = fromNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = fromNumbers(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
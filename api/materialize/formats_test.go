@@ -0,0 +1,195 @@
+package materialize
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/control"
+	"tier.run/mirror/x/exp/slices"
+)
+
+const formatsJSON = `{
+	"plans": {
+		"plan:free@0": {
+			"title": "Free",
+			"features": {
+				"feature:seats": {"base": 100},
+				"feature:api": {
+					"mode": "volume",
+					"tiers": [{"upto": 10}, {"price": 0.5}]
+				}
+			}
+		},
+		"plan:pro@0": {
+			"title": "Pro",
+			"currency": "eur",
+			"features": {
+				"feature:seats": {"base": 100},
+				"feature:api": {
+					"mode": "volume",
+					"tiers": [{"upto": 10}, {"price": 0.5}]
+				}
+			}
+		}
+	}
+}`
+
+const formatsYAML = `
+plans:
+  plan:free@0:
+    title: Free
+    # shared with plan:pro@0
+    features: &features
+      feature:seats:
+        base: 100
+      feature:api:
+        mode: volume
+        tiers:
+          - upto: 10
+          - price: 0.5
+  plan:pro@0:
+    title: Pro
+    currency: eur
+    features:
+      <<: *features
+`
+
+const formatsTOML = `
+[plans."plan:free@0"]
+title = "Free"
+
+[plans."plan:free@0".features."feature:seats"]
+base = 100
+
+[plans."plan:free@0".features."feature:api"]
+mode = "volume"
+tiers = [{upto = 10}, {price = 0.5}]
+
+[plans."plan:pro@0"]
+title = "Pro"
+currency = "eur"
+
+[plans."plan:pro@0".features."feature:seats"]
+base = 100
+
+[plans."plan:pro@0".features."feature:api"]
+mode = "volume"
+tiers = [{upto = 10}, {price = 0.5}]
+`
+
+func sortedFeatures(t *testing.T, data string, f Format) []control.Feature {
+	t.Helper()
+	fs, err := FromPricing([]byte(data), f)
+	if err != nil {
+		t.Fatalf("%s: %v", f, err)
+	}
+	slices.SortFunc(fs, func(a, b control.Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	return fs
+}
+
+func TestFromPricingFormats(t *testing.T) {
+	want := sortedFeatures(t, formatsJSON, FormatJSON)
+	if len(want) != 4 {
+		t.Fatalf("got %d features; want 4", len(want))
+	}
+
+	got := sortedFeatures(t, formatsYAML, FormatYAML)
+	diff.Test(t, t.Errorf, got, want)
+
+	got = sortedFeatures(t, formatsTOML, FormatTOML)
+	diff.Test(t, t.Errorf, got, want)
+}
+
+func TestFromPricingUnknownFields(t *testing.T) {
+	cases := []struct {
+		format Format
+		data   string
+	}{
+		{FormatYAML, "plans:\n  plan:free@0:\n    titel: Free\n"},
+		{FormatYAML, "x-titel: &t Free\nplans:\n  plan:free@0:\n    title: *t\n"},
+		{FormatTOML, "[plans.\"plan:free@0\"]\ntitel = \"Free\"\n"},
+	}
+	for _, tt := range cases {
+		_, err := FromPricing([]byte(tt.data), tt.format)
+		if err == nil || !strings.Contains(err.Error(), "titel") {
+			t.Errorf("%s: err = %v; want unknown field error", tt.format, err)
+		}
+	}
+}
+
+func TestFromPricingYAMLAliasBomb(t *testing.T) {
+	// each level holds ten aliases of the level before it, so the last
+	// expands to 10^9 values
+	var b strings.Builder
+	b.WriteString("x-a0: &a0 [x]\n")
+	for i := 1; i <= 9; i++ {
+		fmt.Fprintf(&b, "x-a%d: &a%d [", i, i)
+		for j := 0; j < 10; j++ {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "*a%d", i-1)
+		}
+		b.WriteString("]\n")
+	}
+	b.WriteString("plans: {}\n")
+
+	_, err := FromPricing([]byte(b.String()), FormatYAML)
+	if err == nil || !strings.Contains(err.Error(), "after expanding aliases") {
+		t.Errorf("err = %v; want error for too many values", err)
+	}
+}
+
+func TestConvertPricingJSON(t *testing.T) {
+	fs := sortedFeatures(t, formatsJSON, FormatJSON)
+	data, err := ToPricingJSON(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+		out, err := ConvertPricingJSON(data, f)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		got := sortedFeatures(t, string(out), f)
+		diff.Test(t, t.Errorf, got, fs)
+	}
+}
+
+func TestFormatDetection(t *testing.T) {
+	names := map[string]Format{
+		"pricing.json": FormatJSON,
+		"pricing":      FormatJSON,
+		"pricing.yaml": FormatYAML,
+		"pricing.YML":  FormatYAML,
+		"pricing.toml": FormatTOML,
+	}
+	for name, want := range names {
+		if got := FormatFromName(name); got != want {
+			t.Errorf("FormatFromName(%q) = %q; want %q", name, got, want)
+		}
+	}
+
+	types := map[string]Format{
+		"":                                FormatJSON,
+		"application/json":                FormatJSON,
+		"application/yaml":                FormatYAML,
+		"application/x-yaml":              FormatYAML,
+		"text/yaml; charset=utf-8":        FormatYAML,
+		"application/toml":                FormatTOML,
+		"application/toml; charset=utf-8": FormatTOML,
+	}
+	for ct, want := range types {
+		if got := FormatFromContentType(ct); got != want {
+			t.Errorf("FormatFromContentType(%q) = %q; want %q", ct, got, want)
+		}
+	}
+
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat(xml): expected error")
+	}
+}
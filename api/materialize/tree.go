@@ -0,0 +1,331 @@
+package materialize
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tailscale/hujson"
+	"gopkg.in/yaml.v3"
+)
+
+// A pos is a 1-based line and column in a pricing document. The zero pos
+// means the position is unknown.
+type pos struct {
+	line, col int
+}
+
+type kind int
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindNumber
+	kindString
+	kindObject
+	kindArray
+	kindOther
+)
+
+var kindNames = [...]string{
+	kindNull:   "null",
+	kindBool:   "a boolean",
+	kindNumber: "a number",
+	kindString: "a string",
+	kindObject: "an object",
+	kindArray:  "an array",
+	kindOther:  "an unsupported value",
+}
+
+func (k kind) String() string { return kindNames[k] }
+
+// A node is a value in a pricing document, independent of its format, that
+// remembers where it came from.
+type node struct {
+	pos
+	kind kind
+
+	str   string  // kindString
+	num   float64 // kindNumber
+	isInt bool    // kindNumber without a fraction or exponent
+
+	members []member // kindObject, in document order
+	elems   []*node  // kindArray
+}
+
+type member struct {
+	key    string
+	keyPos pos
+	val    *node
+}
+
+// parseTree parses data in the format f. Syntax errors are reported as
+// Problems.
+func parseTree(data []byte, f Format) (*node, error) {
+	switch f {
+	case FormatJSON, "":
+		return parseHuJSON(data)
+	case FormatYAML:
+		return parseYAML(data)
+	case FormatTOML:
+		return parseTOML(data)
+	}
+	return nil, fmt.Errorf("unknown pricing format %q", f)
+}
+
+func parseHuJSON(data []byte) (*node, error) {
+	v, err := hujson.Parse(data)
+	if err != nil {
+		p := &Problem{Message: err.Error()}
+		var rest string
+		if n, _ := fmt.Sscanf(err.Error(), "hujson: line %d, column %d:", &p.Line, &p.Column); n == 2 {
+			_, rest, _ = strings.Cut(err.Error(), ": ")
+			_, rest, _ = strings.Cut(rest, ": ")
+			p.Message = rest
+		}
+		return nil, Problems{p}
+	}
+	li := newLineIndex(data)
+	return fromHuJSON(v, li), nil
+}
+
+func fromHuJSON(v hujson.Value, li lineIndex) *node {
+	n := &node{pos: li.pos(v.StartOffset)}
+	switch t := v.Value.(type) {
+	case *hujson.Object:
+		n.kind = kindObject
+		for _, m := range t.Members {
+			key, _ := m.Name.Value.(hujson.Literal)
+			n.members = append(n.members, member{
+				key:    key.String(),
+				keyPos: li.pos(m.Name.StartOffset),
+				val:    fromHuJSON(m.Value, li),
+			})
+		}
+	case *hujson.Array:
+		n.kind = kindArray
+		for _, e := range t.Elements {
+			n.elems = append(n.elems, fromHuJSON(e, li))
+		}
+	case hujson.Literal:
+		switch t.Kind() {
+		case 'n':
+			n.kind = kindNull
+		case 't', 'f':
+			n.kind = kindBool
+		case '"':
+			n.kind = kindString
+			n.str = t.String()
+		case '0':
+			n.kind = kindNumber
+			n.num = t.Float()
+			n.isInt = !bytes.ContainsAny(t, ".eE")
+		default:
+			n.kind = kindOther
+		}
+	default:
+		n.kind = kindOther
+	}
+	return n
+}
+
+func parseYAML(data []byte) (*node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		p := &Problem{Message: err.Error()}
+		var line int
+		if n, _ := fmt.Sscanf(err.Error(), "yaml: line %d:", &line); n == 1 {
+			p.Line = line
+			p.Column = 1
+			_, p.Message, _ = strings.Cut(strings.TrimPrefix(err.Error(), "yaml: "), ": ")
+		}
+		return nil, Problems{p}
+	}
+	if len(doc.Content) == 0 {
+		return &node{kind: kindObject}, nil // empty document
+	}
+	b := &yamlBuilder{}
+	n := b.build(doc.Content[0])
+	if b.nodes > maxYAMLNodes {
+		return nil, Problems{{
+			Line:    b.at.line,
+			Column:  b.at.col,
+			Message: fmt.Sprintf("document has more than %d values after expanding aliases", maxYAMLNodes),
+		}}
+	}
+	return n, nil
+}
+
+// maxYAMLNodes limits the number of values in a YAML document once its
+// aliases are expanded, so that small documents with nested aliases cannot
+// expand to exhaust memory.
+const maxYAMLNodes = 100_000
+
+// yamlBuilder builds nodes from YAML, counting the nodes built.
+type yamlBuilder struct {
+	nodes int
+	at    pos // position of the node that exceeded maxYAMLNodes
+}
+
+func (b *yamlBuilder) build(y *yaml.Node) *node {
+	n := &node{pos: pos{y.Line, y.Column}}
+	b.nodes++
+	if b.nodes > maxYAMLNodes {
+		if b.nodes == maxYAMLNodes+1 {
+			b.at = n.pos
+		}
+		n.kind = kindOther
+		return n
+	}
+	switch y.Kind {
+	case yaml.AliasNode:
+		n = b.build(y.Alias)
+	case yaml.MappingNode:
+		n.kind = kindObject
+		var merges []*yaml.Node
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(y.Content); i += 2 {
+			k, v := y.Content[i], y.Content[i+1]
+			if k.Tag == "!!merge" {
+				merges = append(merges, v)
+				continue
+			}
+			seen[k.Value] = true
+			n.members = append(n.members, member{
+				key:    k.Value,
+				keyPos: pos{k.Line, k.Column},
+				val:    b.build(v),
+			})
+		}
+		// merged keys do not override those set explicitly, and earlier
+		// merges take precedence over later ones
+		for _, m := range merges {
+			for _, src := range mergeSources(m) {
+				for _, sm := range b.build(src).members {
+					if !seen[sm.key] {
+						seen[sm.key] = true
+						n.members = append(n.members, sm)
+					}
+				}
+			}
+		}
+	case yaml.SequenceNode:
+		n.kind = kindArray
+		for _, e := range y.Content {
+			n.elems = append(n.elems, b.build(e))
+		}
+	case yaml.ScalarNode:
+		switch y.ShortTag() {
+		case "!!null":
+			n.kind = kindNull
+		case "!!bool":
+			n.kind = kindBool
+		case "!!str":
+			n.kind = kindString
+			n.str = y.Value
+		case "!!int", "!!float":
+			n.kind = kindNumber
+			n.isInt = y.ShortTag() == "!!int"
+			if err := y.Decode(&n.num); err != nil || math.IsInf(n.num, 0) || math.IsNaN(n.num) {
+				n.kind = kindOther
+			}
+		default:
+			n.kind = kindOther
+		}
+	default:
+		n.kind = kindOther
+	}
+	return n
+}
+
+// mergeSources returns the mappings merged by the value of a YAML merge key,
+// which is either a mapping or a sequence of mappings.
+func mergeSources(v *yaml.Node) []*yaml.Node {
+	if v.Kind == yaml.SequenceNode {
+		return v.Content
+	}
+	return []*yaml.Node{v}
+}
+
+// parseTOML parses TOML data. The TOML decoder does not report the positions
+// of keys, so only syntax errors have positions.
+func parseTOML(data []byte) (*node, error) {
+	m := map[string]any{}
+	if err := toml.Unmarshal(data, &m); err != nil {
+		p := &Problem{Message: err.Error()}
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			at := newLineIndex(data).pos(pe.Position.Start)
+			p.Line, p.Column = at.line, at.col
+			_, p.Message, _ = strings.Cut(strings.TrimPrefix(pe.Error(), "toml: "), ": ")
+		}
+		return nil, Problems{p}
+	}
+	return fromValue(m), nil
+}
+
+// fromValue returns the node for v as decoded by the TOML decoder.
+func fromValue(v any) *node {
+	n := &node{}
+	switch v := v.(type) {
+	case nil:
+		n.kind = kindNull
+	case bool:
+		n.kind = kindBool
+	case string:
+		n.kind = kindString
+		n.str = v
+	case int64:
+		n.kind = kindNumber
+		n.num = float64(v)
+		n.isInt = true
+	case float64:
+		n.kind = kindNumber
+		n.num = v
+	case map[string]any:
+		n.kind = kindObject
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			n.members = append(n.members, member{key: k, val: fromValue(v[k])})
+		}
+	case []map[string]any:
+		n.kind = kindArray
+		for _, e := range v {
+			n.elems = append(n.elems, fromValue(e))
+		}
+	case []any:
+		n.kind = kindArray
+		for _, e := range v {
+			n.elems = append(n.elems, fromValue(e))
+		}
+	default:
+		n.kind = kindOther
+	}
+	return n
+}
+
+// A lineIndex maps byte offsets to line and column numbers.
+type lineIndex []int // offsets of the start of each line
+
+func newLineIndex(data []byte) lineIndex {
+	li := lineIndex{0}
+	for i, b := range data {
+		if b == '\n' {
+			li = append(li, i+1)
+		}
+	}
+	return li
+}
+
+func (li lineIndex) pos(offset int) pos {
+	i := sort.Search(len(li), func(i int) bool { return li[i] > offset }) - 1
+	return pos{line: i + 1, col: offset - li[i] + 1}
+}
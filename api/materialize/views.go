@@ -13,12 +13,25 @@ import (
 	"tier.run/values"
 )
 
+// FromPricingHuJSON returns the features in the pricing model in the HuJSON
+// data. If the model is invalid, the error is of type Problems.
 func FromPricingHuJSON(data []byte) (fs []control.Feature, err error) {
-	var debug []string
-	dbg := func(k string) {
-		debug = append(debug, k)
+	return FromPricing(data, FormatJSON)
+}
+
+// FromPricing is like FromPricingHuJSON but decodes data in the provided
+// format. YAML and TOML documents are held to the same rules as JSON,
+// including rejecting unknown fields. YAML anchors, aliases, and merge keys
+// are expanded before decoding.
+func FromPricing(data []byte, f Format) (fs []control.Feature, err error) {
+	if err := Validate(data, f); err != nil {
+		return nil, err
 	}
 
+	data, err = toJSON(data, f)
+	if err != nil {
+		return nil, err
+	}
 	data, err = hujson.Standardize(data)
 	if err != nil {
 		return nil, err
@@ -28,11 +41,6 @@ func FromPricingHuJSON(data []byte) (fs []control.Feature, err error) {
 	dec := json.NewDecoder(bytes.NewReader(data))
 	dec.DisallowUnknownFields() // we use a Decoder to get the DisallowUnknownFields method
 	if err := dec.Decode(&m); err != nil {
-		dbg("decodeerr")
-		return nil, err
-	}
-
-	if err := validate(m); err != nil {
 		return nil, err
 	}
 
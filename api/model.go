@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/singleflight"
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/stripe"
+	"tier.run/trweb"
+)
+
+// maxWebhookBytes is the largest webhook event body read. Stripe events are
+// far smaller.
+const maxWebhookBytes = 1 << 20
+
+// modelFetchTimeout bounds a shared fetch of a pricing model, which is not
+// canceled with the request that started it.
+const modelFetchTimeout = 2 * time.Minute
+
+// detachedContext carries the values of a context, but not its deadline or
+// cancellation.
+type detachedContext struct{ context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// modelCache holds the pricing model of each account, so that subscribing
+// and checking out does not fetch every price from Stripe.
+type modelCache struct {
+	mu      sync.Mutex
+	entries map[string]*modelEntry // by account ID
+	group   singleflight.Group
+}
+
+type modelEntry struct {
+	fs      []control.Feature // nil if not fetched since last invalidated
+	fetched time.Time
+
+	// gen is incremented each time the entry is invalidated, so that a
+	// fetch started before then does not cache a stale model.
+	gen int
+}
+
+// entry returns the entry for account. It must be called with m.mu held.
+func (m *modelCache) entry(account string) *modelEntry {
+	e := m.entries[account]
+	if e == nil {
+		e = &modelEntry{}
+		if m.entries == nil {
+			m.entries = map[string]*modelEntry{}
+		}
+		m.entries[account] = e
+	}
+	return e
+}
+
+// invalidate marks the model of account as stale, so that it is fetched
+// again on next use.
+func (m *modelCache) invalidate(account string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(account)
+	e.fs = nil
+	e.gen++
+}
+
+// load returns the model for the account c is for. If the cached model is
+// older than ttl, it is fetched using c. Concurrent loads of the same
+// account share one fetch, which is not canceled if ctx is, so that the
+// other loads waiting on it do not fail with it.
+func (m *modelCache) load(ctx context.Context, c *control.Client, ttl time.Duration) ([]control.Feature, error) {
+	account := c.Stripe.AccountID
+	m.mu.Lock()
+	e := m.entry(account)
+	fs, fetched, gen := e.fs, e.fetched, e.gen
+	m.mu.Unlock()
+	if fs != nil && time.Since(fetched) < ttl {
+		return fs, nil
+	}
+
+	v, err := m.group.Do(account+"/"+strconv.Itoa(gen), func() (any, error) {
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, modelFetchTimeout)
+		defer cancel()
+		fs, err := c.Pull(ctx, 0)
+		if err != nil {
+			return nil, err
+		}
+		if fs == nil {
+			fs = []control.Feature{} // distinguish empty from not fetched
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if e := m.entry(account); e.gen == gen {
+			e.fs = fs
+			e.fetched = time.Now()
+		}
+		return fs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]control.Feature), nil
+}
+
+// model returns the pricing model for the account r is made on behalf of.
+// It is cached for h.ModelTTL, if set.
+func (h *Handler) model(r *http.Request) ([]control.Feature, error) {
+	c := h.client(r)
+	if h.ModelTTL <= 0 {
+		return c.Pull(r.Context(), 0)
+	}
+	return h.models.load(r.Context(), c, h.ModelTTL)
+}
+
+// expandModel calls expand with the pricing model for r, and returns the
+// model. If expand fails with control.ErrNoFeatures using a cached model,
+// the model is fetched again and expand is called once more, so that
+// features pushed from elsewhere since the model was cached are found
+// without waiting for it to expire.
+func (h *Handler) expandModel(r *http.Request, expand func([]control.Feature) error) ([]control.Feature, error) {
+	m, err := h.model(r)
+	if err != nil {
+		return nil, err
+	}
+	err = expand(m)
+	if errors.Is(err, control.ErrNoFeatures) && h.ModelTTL > 0 {
+		h.models.invalidate(h.client(r).Stripe.AccountID)
+		m, err = h.model(r)
+		if err != nil {
+			return nil, err
+		}
+		err = expand(m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// serveRefresh discards the cached pricing model for the account r is made
+// on behalf of and fetches it again.
+func (h *Handler) serveRefresh(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return trweb.MethodNotAllowed
+	}
+	h.models.invalidate(h.client(r).Stripe.AccountID)
+	fs, err := h.model(r)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.RefreshResponse{Features: len(fs)})
+}
+
+// serveStripeWebhook receives events from Stripe, and discards the cached
+// pricing model of the account an event is for when its prices or products
+// change. Requests are authenticated by their signature using
+// h.WebhookSecret, and not by token.
+func (h *Handler) serveStripeWebhook(w http.ResponseWriter, r *http.Request) error {
+	if h.WebhookSecret == "" {
+		return errNoRoute
+	}
+	if r.Method != "POST" {
+		return trweb.MethodNotAllowed
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		return err
+	}
+	if err := stripe.VerifyWebhook(body, r.Header.Get("Stripe-Signature"), h.WebhookSecret, stripe.DefaultWebhookTolerance); err != nil {
+		h.Logf("webhook: %v", err)
+		return trweb.Unauthorized
+	}
+
+	var ev struct {
+		Type    string
+		Account string // set for events from connected accounts
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return trweb.InvalidRequest
+	}
+	if !strings.HasPrefix(ev.Type, "price.") && !strings.HasPrefix(ev.Type, "product.") {
+		return nil
+	}
+	account := ev.Account
+	if account == "" {
+		account = h.c.Stripe.AccountID
+	}
+	if account != h.c.Stripe.AccountID && !slices.Contains(h.Accounts, account) {
+		return nil
+	}
+	h.models.invalidate(account)
+	return nil
+}
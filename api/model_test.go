@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/stripe"
+	"tier.run/types/they"
+)
+
+func TestModelCache(t *testing.T) {
+	var pulls atomic.Int64
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/prices"):
+			<-release
+			pulls.Add(1)
+			io.WriteString(w, `{"data": [{
+				"metadata": {"tier.feature": "feature:base@plan:free@1"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "usage_type": "licensed"},
+				"billing_scheme": "per_unit",
+				"unit_amount_decimal": "0"
+			}]}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	cc := &control.Client{
+		Stripe: &stripe.Client{
+			BaseURL:    s.URL,
+			HTTPClient: s.Client(),
+			Logf:       t.Logf,
+		},
+		Logf: t.Logf,
+	}
+	h := NewHandler(cc, t.Logf)
+	h.ModelTTL = time.Hour
+	h.WebhookSecret = "whsec_test"
+	hs := httptest.NewServer(h)
+	t.Cleanup(hs.Close)
+	tc := &tier.Client{BaseURL: hs.URL, HTTPClient: hs.Client(), Logf: t.Logf}
+	ctx := context.Background()
+
+	quote := func() {
+		t.Helper()
+		_, err := tc.Quote(ctx, apitypes.QuoteRequest{Features: []string{"plan:free@1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantPulls := func(want int64) {
+		t.Helper()
+		if got := pulls.Load(); got != want {
+			t.Errorf("pulls = %d; want %d", got, want)
+		}
+	}
+
+	// concurrent requests share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := tc.Quote(ctx, apitypes.QuoteRequest{Features: []string{"plan:free@1"}})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	wantPulls(1)
+
+	quote()
+	wantPulls(1)
+
+	rr, err := tc.Refresh(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.Features != 1 {
+		t.Errorf("features = %d; want 1", rr.Features)
+	}
+	wantPulls(2)
+	quote()
+	wantPulls(2)
+
+	if _, err := tc.PushJSON(ctx, []byte(`{"plans": {}}`)); err != nil {
+		t.Fatal(err)
+	}
+	quote()
+	wantPulls(3)
+
+	webhook := func(secret, body string) int {
+		t.Helper()
+		now := time.Now().Unix()
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "%d.%s", now, body)
+		req, err := http.NewRequest("POST", hs.URL+"/v1/webhooks/stripe", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%x", now, mac.Sum(nil)))
+		resp, err := hs.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := webhook("whsec_test", `{"type": "customer.created"}`); code != 200 {
+		t.Errorf("status = %d; want 200", code)
+	}
+	quote()
+	wantPulls(3)
+
+	if code := webhook("whsec_wrong", `{"type": "price.updated"}`); code != 401 {
+		t.Errorf("status = %d; want 401", code)
+	}
+	quote()
+	wantPulls(3)
+
+	if code := webhook("whsec_test", `{"type": "price.updated", "account": "acct_other"}`); code != 200 {
+		t.Errorf("status = %d; want 200", code)
+	}
+	quote()
+	wantPulls(3)
+
+	if code := webhook("whsec_test", `{"type": "price.updated"}`); code != 200 {
+		t.Errorf("status = %d; want 200", code)
+	}
+	quote()
+	wantPulls(4)
+
+	h.WebhookSecret = ""
+	if code := webhook("whsec_test", `{"type": "price.updated"}`); code != 404 {
+		t.Errorf("status = %d; want 404 without a secret", code)
+	}
+}
+
+func TestModelCacheLeaderCanceled(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !they.Want(r, "GET", "/v1/prices") {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+			return
+		}
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, `{"data": []}`)
+	}))
+	t.Cleanup(s.Close)
+
+	c := &control.Client{
+		Stripe: &stripe.Client{
+			BaseURL:    s.URL,
+			HTTPClient: s.Client(),
+			Logf:       t.Logf,
+		},
+		Logf: t.Logf,
+	}
+	var m modelCache
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := m.load(leaderCtx, c, time.Hour)
+		leaderErr <- err
+	}()
+	<-started
+
+	followerErr := make(chan error, 1)
+	go func() {
+		_, err := m.load(context.Background(), c, time.Hour)
+		followerErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // let the follower join the fetch
+
+	cancel()
+	time.Sleep(10 * time.Millisecond) // let a canceled fetch fail
+	close(release)
+
+	if err := <-followerErr; err != nil {
+		t.Errorf("follower: %v", err)
+	}
+	if err := <-leaderErr; err != nil {
+		t.Errorf("leader: %v", err)
+	}
+	if len(started) != 0 {
+		t.Errorf("model fetched %d more times; want one shared fetch", len(started))
+	}
+}
+
+func TestModelCacheMiss(t *testing.T) {
+	const price = `{
+		"metadata": {"tier.feature": "feature:base@%s"},
+		"currency": "usd",
+		"recurring": {"interval": "month", "usage_type": "licensed"},
+		"billing_scheme": "per_unit",
+		"unit_amount_decimal": "0"
+	}`
+	var mu sync.Mutex
+	plans := []string{"plan:free@1"}
+	var pulls atomic.Int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !they.Want(r, "GET", "/v1/prices") {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+			return
+		}
+		pulls.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		var data []string
+		for _, p := range plans {
+			data = append(data, fmt.Sprintf(price, p))
+		}
+		fmt.Fprintf(w, `{"data": [%s]}`, strings.Join(data, ","))
+	}))
+	t.Cleanup(s.Close)
+
+	cc := &control.Client{
+		Stripe: &stripe.Client{
+			BaseURL:    s.URL,
+			HTTPClient: s.Client(),
+			Logf:       t.Logf,
+		},
+		Logf: t.Logf,
+	}
+	h := NewHandler(cc, t.Logf)
+	h.ModelTTL = time.Hour
+	hs := httptest.NewServer(h)
+	t.Cleanup(hs.Close)
+	tc := &tier.Client{BaseURL: hs.URL, HTTPClient: hs.Client(), Logf: t.Logf}
+	ctx := context.Background()
+
+	quote := func(plan string) error {
+		_, err := tc.Quote(ctx, apitypes.QuoteRequest{Features: []string{plan}})
+		return err
+	}
+	if err := quote("plan:free@1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// pushed from elsewhere, without a webhook to refresh the model
+	mu.Lock()
+	plans = append(plans, "plan:pro@1")
+	mu.Unlock()
+
+	if err := quote("plan:pro@1"); err != nil {
+		t.Fatalf("quote for plan pushed since the model was cached: %v", err)
+	}
+	if got := pulls.Load(); got != 2 {
+		t.Errorf("pulls = %d; want 2", got)
+	}
+	if err := quote("plan:pro@1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := pulls.Load(); got != 2 {
+		t.Errorf("pulls = %d; want 2 once cached", got)
+	}
+
+	// plans that do not exist are reported after fetching once more
+	if err := quote("plan:nope@1"); !isAPIErrorCode(err, "TERR1020") {
+		t.Fatalf("err = %v; want TERR1020", err)
+	}
+	if got := pulls.Load(); got != 3 {
+		t.Errorf("pulls = %d; want 3", got)
+	}
+}
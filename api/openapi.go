@@ -0,0 +1,368 @@
+package api
+
+import (
+	_ "embed"
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+	"tier.run/trweb"
+)
+
+// openAPIJSON is the OpenAPI document served at /v1/openapi.json. It is
+// generated from routes and the apitypes structs by generateOpenAPI; run
+//
+//	go test ./api -run TestOpenAPI -update
+//
+// after changing either.
+//
+//go:embed openapi.json
+var openAPIJSON []byte
+
+func (h *Handler) serveOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+	return nil
+}
+
+// A route describes an API route for the OpenAPI document.
+type route struct {
+	path    string
+	method  string
+	summary string
+	query   []string // query parameters; all are strings
+	header  []string // header parameters, other than Tier-Clock and Tier-Account
+	body    any      // request body type, or nil
+	resp    any      // response body type, or nil for an empty object
+	public  bool     // does not require a token
+}
+
+// routes are the routes served by Handler.serve, in the order they are
+// documented.
+var routes = []route{
+	{path: "/healthz", method: "GET", public: true,
+		summary: "Report that the sidecar is running.",
+		resp:    statusResponse{}},
+	{path: "/readyz", method: "GET", public: true,
+		summary: "Report that Stripe is reachable and accepts the configured API key.",
+		resp:    statusResponse{}},
+	{path: "/v1/openapi.json", method: "GET", public: true,
+		summary: "Return this document."},
+	{path: "/v1/webhooks/stripe", method: "POST", public: true,
+		summary: "Receive Stripe webhook events, authenticated by their signature. Served only if a webhook secret is configured."},
+	{path: "/v1/whoami", method: "GET",
+		summary: "Return the Stripe account in use.",
+		resp:    apitypes.WhoAmIResponse{}},
+	{path: "/v1/whois", method: "GET",
+		summary: "Return the Stripe customer ID for an org, and optionally its info.",
+		query:   []string{"org", "include"},
+		resp:    apitypes.WhoIsResponse{}},
+	{path: "/v1/limits", method: "GET",
+		summary: "Return the usage and limits of the features an org is subscribed to.",
+		query:   []string{"org"},
+		resp:    apitypes.UsageResponse{}},
+	{path: "/v1/report", method: "POST",
+		summary: "Report usage of a feature by an org.",
+		header:  []string{"Idempotency-Key"},
+		body:    apitypes.ReportRequest{}},
+	{path: "/v1/consume", method: "POST",
+		summary: "Report usage of a feature by an org only if it is within the org's limit.",
+		body:    apitypes.ConsumeRequest{},
+		resp:    apitypes.ConsumeResponse{}},
+	{path: "/v1/subscribe", method: "POST",
+		summary: "Update an org's info and subscription schedule.",
+		body:    apitypes.ScheduleRequest{},
+		resp:    apitypes.ScheduleResponse{}},
+	{path: "/v1/checkout", method: "POST",
+		summary: "Create a Stripe Checkout session for an org.",
+		body:    apitypes.CheckoutRequest{},
+		resp:    apitypes.CheckoutResponse{}},
+	{path: "/v1/phases", method: "GET",
+		summary: "Return all phases of an org's subscription schedule. Experimental.",
+		query:   []string{"org"},
+		resp:    apitypes.PhasesResponse{}},
+	{path: "/v1/phase", method: "GET",
+		summary: "Return the current phase of an org's subscription schedule.",
+		query:   []string{"org"},
+		resp:    apitypes.PhaseResponse{}},
+	{path: "/v1/pull", method: "GET",
+		summary: "Return the pricing model.",
+		resp:    apitypes.Model{}},
+	{path: "/v1/push", method: "POST",
+		summary: "Create the features and plans in a pricing model.",
+		body:    apitypes.Model{},
+		resp:    apitypes.PushResponse{}},
+	{path: "/v1/refresh", method: "POST",
+		summary: "Fetch the pricing model from Stripe again, discarding the cached copy.",
+		resp:    apitypes.RefreshResponse{}},
+	{path: "/v1/quote", method: "POST",
+		summary: "Return the cost of plans and features for an amount of usage over one billing period.",
+		body:    apitypes.QuoteRequest{},
+		resp:    apitypes.QuoteResponse{}},
+	{path: "/v1/drift", method: "POST",
+		summary: "Return the differences between a pricing model and the prices and products in Stripe.",
+		body:    apitypes.Model{},
+		resp:    apitypes.DriftResponse{}},
+	{path: "/v1/reports/revenue", method: "GET",
+		summary: "Return the monthly recurring revenue of each plan, by currency.",
+		resp:    apitypes.RevenueResponse{}},
+	{path: "/v1/payment_methods", method: "GET",
+		summary: "Return an org's payment methods.",
+		query:   []string{"org"},
+		resp:    apitypes.PaymentMethodsResponse{}},
+	{path: "/v1/clock", method: "GET",
+		summary: "Return a test clock.",
+		query:   []string{"id"},
+		resp:    apitypes.ClockResponse{}},
+	{path: "/v1/clock", method: "POST",
+		summary: "Create a test clock, or advance one if an ID is given.",
+		body:    apitypes.ClockRequest{},
+		resp:    apitypes.ClockResponse{}},
+	{path: "/v1/clock", method: "DELETE",
+		summary: "Delete a test clock, and the orgs and subscriptions created under it.",
+		query:   []string{"id"}},
+	{path: "/v1/clocks", method: "GET",
+		summary: "Return all test clocks, newest first.",
+		resp:    apitypes.ClocksResponse{}},
+}
+
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// errorCodes returns all error codes the API may respond with.
+func errorCodes() []string {
+	seen := map[string]bool{}
+	for _, e := range errorLookup {
+		seen[e.Code] = true
+	}
+	for _, e := range []error{
+		trweb.NotFound,
+		trweb.Unauthorized,
+		trweb.Forbidden,
+		trweb.InternalError,
+		trweb.MethodNotAllowed,
+		trweb.InvalidRequest,
+		errNotReady,
+		errAccountNotAllowed,
+	} {
+		seen[e.(*trweb.HTTPError).Code] = true
+	}
+	// written directly by ServeHTTP
+	seen["account_invalid"] = true
+	seen["invalid_payment_method"] = true
+	seen["invalid_pricing"] = true
+
+	codes := make([]string, 0, len(seen))
+	for c := range seen {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// generateOpenAPI returns the OpenAPI 3 document describing routes.
+func generateOpenAPI() ([]byte, error) {
+	g := &schemaGen{schemas: map[string]any{}}
+
+	errSchema := g.schema(reflect.TypeOf(apitypes.Error{}))
+	g.schemas["Error"].(map[string]any)["properties"].(map[string]any)["code"] = map[string]any{
+		"type": "string",
+		"enum": errorCodes(),
+	}
+
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		var params []any
+		if !rt.public {
+			params = append(params,
+				map[string]any{"$ref": "#/components/parameters/Account"},
+				map[string]any{"$ref": "#/components/parameters/Clock"},
+			)
+		}
+		for _, q := range rt.query {
+			params = append(params, map[string]any{
+				"name":   q,
+				"in":     "query",
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, hdr := range rt.header {
+			params = append(params, map[string]any{
+				"name":   hdr,
+				"in":     "header",
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+
+		resp := map[string]any{"type": "object"}
+		if rt.resp != nil {
+			resp = g.schema(reflect.TypeOf(rt.resp))
+		}
+		op := map[string]any{
+			"summary": rt.summary,
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "OK",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": resp},
+					},
+				},
+				"default": map[string]any{
+					"description": "Error",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": errSchema},
+					},
+				},
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": g.schema(reflect.TypeOf(rt.body)),
+					},
+				},
+			}
+		}
+		if rt.public {
+			op["security"] = []any{}
+		}
+		if paths[rt.path] == nil {
+			paths[rt.path] = map[string]any{}
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Tier sidecar API",
+			"version": "1",
+		},
+		"paths": paths,
+		"security": []any{
+			map[string]any{"bearerAuth": []any{}},
+		},
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			},
+			"parameters": map[string]any{
+				"Account": map[string]any{
+					"name":        tier.AccountHeader,
+					"in":          "header",
+					"description": "The ID of the Stripe connected account to make the request on behalf of. It must be one of the accounts the sidecar is configured to allow.",
+					"schema":      map[string]any{"type": "string"},
+				},
+				"Clock": map[string]any{
+					"name":        tier.ClockHeader,
+					"in":          "header",
+					"description": "The ID of the Stripe test clock to use for the request.",
+					"schema":      map[string]any{"type": "string"},
+				},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaGen generates JSON schemas for Go types following the encoding/json
+// rules. Named struct types are added to schemas and referenced by name.
+type schemaGen struct {
+	schemas map[string]any
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Implements(textMarshalerType):
+		return map[string]any{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := g.schemas[t.Name()]; ok {
+			return ref
+		}
+		s := map[string]any{"type": "object"}
+		g.schemas[t.Name()] = s // before fields, for recursive types
+		props := map[string]any{}
+		g.fields(t, props)
+		if len(props) > 0 {
+			s["properties"] = props
+		}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// fields adds the JSON properties of the struct type t to props, including
+// those of embedded structs.
+func (g *schemaGen) fields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, props)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+}
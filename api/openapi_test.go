@@ -0,0 +1,93 @@
+package api
+
+import (
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+var update = flag.Bool("update", false, "update openapi.json")
+
+func TestOpenAPI(t *testing.T) {
+	got, err := generateOpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile("openapi.json", got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if string(got) != string(openAPIJSON) {
+		diff.Test(t, t.Errorf, string(got), string(openAPIJSON))
+		t.Error("openapi.json is out of date; run: go test ./api -run TestOpenAPI -update")
+	}
+}
+
+// TestOpenAPIRoutes checks that every path in Handler.serve is documented,
+// and nothing else.
+func TestOpenAPIRoutes(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "api.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var served []string
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Name.Name != "serve" || fd.Recv == nil {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			cc, ok := n.(*ast.CaseClause)
+			if !ok {
+				return true
+			}
+			for _, e := range cc.List {
+				if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					p, _ := strconv.Unquote(lit.Value)
+					served = append(served, p)
+				}
+			}
+			return true
+		})
+	}
+
+	seen := map[string]bool{}
+	var documented []string
+	for _, rt := range routes {
+		if !seen[rt.path] {
+			seen[rt.path] = true
+			documented = append(documented, rt.path)
+		}
+	}
+
+	sort.Strings(served)
+	sort.Strings(documented)
+	diff.Test(t, t.Errorf, documented, served)
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	h := NewHandler(nil, t.Logf)
+	h.Auth = &Auth{Tokens: []string{"secret"}}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/openapi.json", nil))
+	if w.Code != 200 {
+		t.Fatalf("status = %d; want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q; want application/json", got)
+	}
+	if w.Body.String() != string(openAPIJSON) {
+		t.Error("unexpected body")
+	}
+}
@@ -0,0 +1,125 @@
+// Package audit records the mutating operations made through the sidecar, so
+// that it is known who changed what, and when.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// An Entry records one mutating request to the sidecar.
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	// Caller is the name the caller gave in the Tier-Caller header, if
+	// any. It is not authenticated; Token is.
+	Caller string `json:"caller,omitempty"`
+
+	// Token is a fingerprint of the token the request was made with, if
+	// any, so that callers can be told apart without logging tokens.
+	Token string `json:"token,omitempty"`
+
+	Account string `json:"account,omitempty"` // Stripe connected account, if any
+	Clock   string `json:"clock,omitempty"`   // test clock, if any
+
+	Method  string `json:"method"`
+	Route   string `json:"route"`
+	Org     string `json:"org,omitempty"`
+	Summary string `json:"summary,omitempty"` // of the request body
+
+	// StripeRequestIDs are the IDs of the requests made to Stripe while
+	// serving the request, for finding them in the Stripe dashboard.
+	StripeRequestIDs []string `json:"stripe_request_ids,omitempty"`
+
+	Status int    `json:"status"`          // HTTP status code of the response
+	Error  string `json:"error,omitempty"` // if the request failed
+}
+
+// A Sink records audit entries. Implementations must be safe for concurrent
+// use.
+type Sink interface {
+	Record(Entry) error
+}
+
+// A File is a Sink that appends entries to a file as JSON lines.
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile opens the named file for appending entries, creating it if
+// needed.
+func OpenFile(name string) (*File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f}, nil
+}
+
+// Record implements Sink. Each entry is written with a single write so that
+// entries are not interleaved, even with other processes appending to the
+// same file.
+func (f *File) Record(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.f.Write(b)
+	return err
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// A Filter selects entries. The zero Filter selects all entries.
+type Filter struct {
+	Org   string    // if set, only entries for Org
+	Since time.Time // if set, only entries at or after Since
+	Until time.Time // if set, only entries before Until
+}
+
+// Match reports if e is selected by f.
+func (f Filter) Match(e Entry) bool {
+	if f.Org != "" && e.Org != f.Org {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Read returns the entries in the JSON lines read from r that are selected
+// by f, in the order read.
+func Read(r io.Reader, f Filter) ([]Entry, error) {
+	var es []Entry
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("audit: line %d: %w", line, err)
+		}
+		if f.Match(e) {
+			es = append(es, e)
+		}
+	}
+	return es, sc.Err()
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			org := "org:a"
+			if i%2 == 1 {
+				org = "org:b"
+			}
+			err := f.Record(Entry{
+				Time:   t0.Add(time.Duration(i) * time.Hour),
+				Method: "POST",
+				Route:  "/v1/subscribe",
+				Org:    org,
+				Status: 200,
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	es, err := Read(r, Filter{
+		Org:   "org:a",
+		Since: t0.Add(2 * time.Hour),
+		Until: t0.Add(8 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []time.Time
+	for _, e := range es {
+		got = append(got, e.Time)
+	}
+	diff.Test(t, t.Errorf, len(got), 3) // hours 2, 4, and 6
+	for _, tm := range got {
+		if h := tm.Sub(t0).Hours(); h != 2 && h != 4 && h != 6 {
+			t.Errorf("unexpected entry at hour %v", h)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, err := Read(strings.NewReader("{}\n\nnot json\n"), Filter{})
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("err = %v; want error on line 3", err)
+	}
+}
@@ -8,9 +8,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"tailscale.com/logtail/backoff"
@@ -23,16 +26,40 @@ import (
 // It is exported for use by the sidecar API. Most users want to use WithClock.
 const ClockHeader = "Tier-Clock"
 
+// AccountHeader is the header used to pass the Stripe connected account ID
+// to the tier sidecar. It is exported for use by the sidecar API. Most users
+// want to use WithAccount.
+const AccountHeader = "Tier-Account"
+
+// CallerHeader is the header used to pass the name of the caller to the tier
+// sidecar, for its audit log. It is exported for use by the sidecar API.
+// Most users want to set Client.Caller.
+const CallerHeader = "Tier-Caller"
+
 const Inf = 1<<63 - 1
 
 type Client struct {
 	// APIKey is the API key used, and set in the Authorization header.
 	APIKey string
 
-	BaseURL    string // the base URL of the tier sidecar; default is http://127.0.0.1:8080
+	// Token is the bearer token sent to a sidecar started with tokens
+	// configured. If set, it is used in place of APIKey.
+	Token string
+
+	// BaseURL is the base URL of the tier sidecar; default is
+	// http://127.0.0.1:8080. A URL of the form unix:///path/to/tier.sock
+	// connects to a sidecar listening on a Unix domain socket.
+	BaseURL    string
 	HTTPClient *http.Client
 
+	// Caller, if set, names the service or person making requests. It is
+	// recorded in the sidecar's audit log alongside the token used.
+	Caller string
+
 	Logf func(fmt string, args ...any)
+
+	unixOnce   sync.Once
+	unixClient *http.Client
 }
 
 func (c *Client) logf(fmt string, args ...any) {
@@ -54,9 +81,25 @@ func clockFromContext(ctx context.Context) string {
 	return id
 }
 
+type accountKey struct{}
+
+// WithAccount returns a context with the provided Stripe connected account ID
+// set. Requests made with the context are made on behalf of the account
+// which must be in the sidecar's list of allowed accounts. The account ID is
+// passed via the Tier-Account header.
+func (c *Client) WithAccount(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, accountKey{}, id)
+}
+
+func accountFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(accountKey{}).(string)
+	return id
+}
+
 // FromEnv returns a Client configured from the environment. The BaseURL is set
 // to the value of the TIER_BASE_URL environment variable, or
-// http://127.0.0.1:8080 if unset.
+// http://127.0.0.1:8080 if unset. TIER_BASE_URL may be a unix:// URL. The
+// Token is set to the value of the TIER_SIDECAR_TOKEN environment variable.
 //
 // It returns an error if the TIER_BASE_URL environment variable is set to an
 // invalid URL.
@@ -75,20 +118,48 @@ func FromEnv() (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{BaseURL: baseURL, APIKey: key}, nil
+	return &Client{
+		BaseURL: baseURL,
+		APIKey:  key,
+		Token:   os.Getenv("TIER_SIDECAR_TOKEN"),
+	}, nil
 }
 
 const defaultBaseURL = "http://127.0.0.1:8080"
 
 func (c *Client) baseURL(pathStartingWithSlash string) string {
+	if _, ok := unixSocketPath(c.BaseURL); ok {
+		// the host is ignored by the unix dialer
+		return "http://tier" + pathStartingWithSlash
+	}
 	return c.BaseURL + pathStartingWithSlash
 }
 
 func (c *Client) client() *http.Client {
-	if c.HTTPClient == nil {
-		return http.DefaultClient
+	if c.HTTPClient != nil {
+		return c.HTTPClient
 	}
-	return c.HTTPClient
+	if path, ok := unixSocketPath(c.BaseURL); ok {
+		c.unixOnce.Do(func() {
+			c.unixClient = &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, "unix", path)
+					},
+				},
+			}
+		})
+		return c.unixClient
+	}
+	return http.DefaultClient
+}
+
+// unixSocketPath reports the socket path in baseURL if it has the form
+// unix://path.
+func unixSocketPath(baseURL string) (path string, ok bool) {
+	path, ok = strings.CutPrefix(baseURL, "unix://")
+	return path, ok && path != ""
 }
 
 // Push pushes the provided pricing model to Stripe.
@@ -111,6 +182,31 @@ func (c *Client) PullJSON(ctx context.Context) ([]byte, error) {
 	return fetchOK[[]byte, *apitypes.Error](ctx, c, "GET", "/v1/pull", nil)
 }
 
+// Quote reports the cost of the plans and features in q for the usage in q
+// over one billing period. The cost is computed from the pricing model
+// without creating anything in Stripe.
+func (c *Client) Quote(ctx context.Context, q apitypes.QuoteRequest) (apitypes.QuoteResponse, error) {
+	return fetchOK[apitypes.QuoteResponse, *apitypes.Error](ctx, c, "POST", "/v1/quote", q)
+}
+
+// DriftJSON reports the differences between the pricing model in m and the
+// prices and products in Stripe, such as metadata edited or prices
+// deactivated in the Stripe dashboard.
+func (c *Client) DriftJSON(ctx context.Context, m []byte) (apitypes.DriftResponse, error) {
+	return fetchOK[apitypes.DriftResponse, *apitypes.Error](ctx, c, "POST", "/v1/drift", json.RawMessage(m))
+}
+
+// Revenue reports the monthly recurring revenue of each plan, by currency.
+func (c *Client) Revenue(ctx context.Context) (apitypes.RevenueResponse, error) {
+	return fetchOK[apitypes.RevenueResponse, *apitypes.Error](ctx, c, "GET", "/v1/reports/revenue", nil)
+}
+
+// Refresh makes the sidecar fetch the pricing model from Stripe again,
+// discarding its cached copy.
+func (c *Client) Refresh(ctx context.Context) (apitypes.RefreshResponse, error) {
+	return fetchOK[apitypes.RefreshResponse, *apitypes.Error](ctx, c, "POST", "/v1/refresh", nil)
+}
+
 // WhoIs reports the Stripe customer ID for the provided org. OrgInfo is not set.
 func (c *Client) WhoIs(ctx context.Context, org string) (apitypes.WhoIsResponse, error) {
 	return fetchOK[apitypes.WhoIsResponse, *apitypes.Error](ctx, c, "GET", "/v1/whois?org="+org, nil)
@@ -223,11 +319,29 @@ func (c *Client) Can(ctx context.Context, org, feature string) Answer {
 		return Answer{}
 	}
 	report := func(n int) error {
-		return c.Report(ctx, org, feature, 1)
+		return c.Report(ctx, org, feature, n)
 	}
 	return Answer{ok: true, report: report}
 }
 
+// Consume atomically checks if org may use n more units of feature and, if so,
+// reports the usage. Unlike Can followed by Report, concurrent calls to Consume
+// for the same org and feature cannot together exceed the limit.
+//
+// If the usage would exceed the limit, the response has OK set to false, no
+// usage is reported, and no error is returned.
+func (c *Client) Consume(ctx context.Context, org, feature string, n int) (apitypes.ConsumeResponse, error) {
+	fn, err := refs.ParseName(feature)
+	if err != nil {
+		return apitypes.ConsumeResponse{}, err
+	}
+	return fetchOK[apitypes.ConsumeResponse, *apitypes.Error](ctx, c, "POST", "/v1/consume", apitypes.ConsumeRequest{
+		Org:     org,
+		Feature: fn,
+		N:       n,
+	})
+}
+
 // Report reports a usage of n for the provided org and feature at the current
 // time.
 func (c *Client) Report(ctx context.Context, org, feature string, n int) error {
@@ -247,6 +361,11 @@ func (c *Client) Report(ctx context.Context, org, feature string, n int) error {
 type ReportParams struct {
 	At      time.Time // default is 'now' at Stripe
 	Clobber bool      // default is false
+
+	// IdempotencyKey optionally identifies the usage event being reported.
+	// Retried reports with the same org, feature, and key are counted only
+	// once. The default is a random key per call.
+	IdempotencyKey string
 }
 
 // ReportUsage reports usage based on the provided ReportRequest fields.
@@ -265,6 +384,8 @@ func (c *Client) ReportUsage(ctx context.Context, org, feature string, n int, rp
 		N:       n,
 		At:      p.At,
 		Clobber: p.Clobber,
+
+		IdempotencyKey: p.IdempotencyKey,
 	}
 	_, err = fetchOK[struct{}, *apitypes.Error](ctx, c, "POST", "/v1/report", r)
 	return err
@@ -354,16 +475,39 @@ func (c *Client) WithClock(ctx context.Context, name string, start time.Time) (c
 	if clockFromContext(ctx) != "" {
 		return nil, errors.New("tier: clock already set in context")
 	}
-	clock, err := fetchOK[apitypes.ClockResponse, *apitypes.Error](ctx, c, "POST", "/v1/clock", apitypes.ClockRequest{
-		Name:    name,
-		Present: start,
-	})
+	clock, err := c.NewClock(ctx, name, start)
 	if err != nil {
 		return nil, err
 	}
 	return WithClock(ctx, clock.ID), nil
 }
 
+// NewClock creates a new test clock with the provided name and start time.
+// Most users want to use WithClock.
+func (c *Client) NewClock(ctx context.Context, name string, start time.Time) (apitypes.ClockResponse, error) {
+	return fetchOK[apitypes.ClockResponse, *apitypes.Error](ctx, c, "POST", "/v1/clock", apitypes.ClockRequest{
+		Name:    name,
+		Present: start,
+	})
+}
+
+// LookupClock reports the test clock with the provided ID.
+func (c *Client) LookupClock(ctx context.Context, id string) (apitypes.ClockResponse, error) {
+	return fetchOK[apitypes.ClockResponse, *apitypes.Error](ctx, c, "GET", "/v1/clock?id="+url.QueryEscape(id), nil)
+}
+
+// ListClocks reports all test clocks, newest first.
+func (c *Client) ListClocks(ctx context.Context) (apitypes.ClocksResponse, error) {
+	return fetchOK[apitypes.ClocksResponse, *apitypes.Error](ctx, c, "GET", "/v1/clocks", nil)
+}
+
+// DeleteClock deletes the test clock with the provided ID, along with all
+// orgs and subscriptions created under it.
+func (c *Client) DeleteClock(ctx context.Context, id string) error {
+	_, err := fetchOK[struct{}, *apitypes.Error](ctx, c, "DELETE", "/v1/clock?id="+url.QueryEscape(id), nil)
+	return err
+}
+
 // Advance advances the test clock set in the context to t.
 //
 // It is an error to call Advance if no clock is set in the context.
@@ -387,7 +531,7 @@ var errClockNotReady = errors.New("force backoff")
 func (c *Client) awaitClockReady(ctx context.Context, id string) error {
 	bo := backoff.NewBackoff("tier", c.logf, 5*time.Second)
 	for {
-		cr, err := c.syncClock(ctx, id)
+		cr, err := c.LookupClock(ctx, id)
 		if err != nil || cr.Status != "ready" {
 			c.logf("clock %s not ready: err=%v status=%q; retrying", id, err, cr.Status)
 			bo.BackOff(ctx, errClockNotReady)
@@ -397,16 +541,21 @@ func (c *Client) awaitClockReady(ctx context.Context, id string) error {
 	}
 }
 
-func (c *Client) syncClock(ctx context.Context, id string) (apitypes.ClockResponse, error) {
-	return fetchOK[apitypes.ClockResponse, *apitypes.Error](ctx, c, "GET", "/v1/clock?id="+id, nil)
-}
-
 func fetchOK[T any, E error](ctx context.Context, c *Client, method, path string, body any) (T, error) {
-	up := url.UserPassword(c.APIKey, "")
-	clockID := clockFromContext(ctx)
-	var h http.Header
-	if clockID != "" {
-		h = http.Header{ClockHeader: []string{clockID}}
+	h := http.Header{}
+	if clockID := clockFromContext(ctx); clockID != "" {
+		h.Set(ClockHeader, clockID)
+	}
+	if accountID := accountFromContext(ctx); accountID != "" {
+		h.Set(AccountHeader, accountID)
 	}
+	if c.Caller != "" {
+		h.Set(CallerHeader, c.Caller)
+	}
+	if c.Token != "" {
+		h.Set("Authorization", "Bearer "+c.Token)
+		return fetch.OK[T, E](ctx, c.client(), method, c.baseURL(path), body, h)
+	}
+	up := url.UserPassword(c.APIKey, "")
 	return fetch.OK[T, E](ctx, c.client(), method, c.baseURL(path), body, up, h)
 }
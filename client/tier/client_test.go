@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -125,3 +128,95 @@ func TestReportNow(t *testing.T) {
 
 	diff.Test(t, t.Errorf, got, want)
 }
+
+func TestCanReportN(t *testing.T) {
+	var mu sync.Mutex
+	var got []int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/limits":
+			io.WriteString(w, `{"usage": [{"feature": "feature:x", "limit": 10, "used": 1}]}`)
+		case "/v1/report":
+			var v apitypes.ReportRequest
+			if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			got = append(got, v.N)
+			mu.Unlock()
+			io.WriteString(w, "{}")
+		default:
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+	}))
+	defer s.Close()
+
+	c := &Client{BaseURL: s.URL}
+	ans := c.Can(context.Background(), "org:foo", "feature:x")
+	if !ans.OK() {
+		t.Fatalf("OK = false; want true (err: %v)", ans.Err())
+	}
+	if err := ans.ReportN(3); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []int{3})
+}
+
+func TestToken(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Header.Get("Authorization"))
+		mu.Unlock()
+		io.WriteString(w, "{}")
+	}))
+	defer s.Close()
+
+	t.Setenv("TIER_BASE_URL", s.URL)
+	t.Setenv("TIER_API_KEY", "")
+	t.Setenv("TIER_SIDECAR_TOKEN", "secret")
+
+	c, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Pull(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []string{"Bearer secret"})
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tier.sock")
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"org": "org:test", "stripe_id": "cus_123"}`)
+	}))
+	s.Listener = ln
+	s.Start()
+	defer s.Close()
+
+	t.Setenv("TIER_BASE_URL", "unix://"+path)
+	t.Setenv("TIER_API_KEY", "")
+	c, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.WhoIs(context.Background(), "org:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StripeID != "cus_123" {
+		t.Errorf("StripeID = %q; want %q", got.StripeID, "cus_123")
+	}
+}
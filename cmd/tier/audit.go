@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"tier.run/audit"
+)
+
+// runAudit prints the entries in the audit log named in args that match the
+// flags in args.
+func runAudit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	org := fs.String("org", "", "only show entries for the org")
+	since := fs.String("since", "", "only show entries at or after a time, date, or duration ago")
+	until := fs.String("until", "", "only show entries before a time, date, or duration ago")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	switch *format {
+	case "table", "json":
+	default:
+		return fmt.Errorf("unknown format %q; must be table or json", *format)
+	}
+
+	now := time.Now()
+	f := audit.Filter{Org: *org}
+	var err error
+	if f.Since, err = parseAuditTime(*since, now); err != nil {
+		return fmt.Errorf("since: %w", err)
+	}
+	if f.Until, err = parseAuditTime(*until, now); err != nil {
+		return fmt.Errorf("until: %w", err)
+	}
+
+	r, _, err := stdinRemoteOrFile(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	es, err := audit.Read(r, f)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		for _, e := range es {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return printAudit(stdout, es)
+}
+
+// parseAuditTime parses s as an RFC 3339 time, a date in the local time
+// zone, or a duration before now. The empty string is the zero time.
+func parseAuditTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q; use a time like 2026-01-02T15:04:05Z, a date like 2026-01-02, or a duration like 24h", s)
+}
+
+func printAudit(w io.Writer, es []audit.Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tCALLER\tORG\tROUTE\tSTATUS\tSUMMARY")
+	for _, e := range es {
+		caller := e.Caller
+		if caller == "" && e.Token != "" {
+			caller = "token:" + e.Token
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Format(time.RFC3339),
+			dashIfEmpty(caller),
+			dashIfEmpty(e.Org),
+			e.Method+" "+e.Route,
+			strconv.Itoa(e.Status),
+			dashIfEmpty(e.Summary),
+		)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"2026-01-02T15:04:05Z", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2026-01-02", time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)},
+		{"24h", now.Add(-24 * time.Hour)},
+	}
+	for _, tt := range cases {
+		got, err := parseAuditTime(tt.in, now)
+		if err != nil {
+			t.Errorf("parseAuditTime(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseAuditTime(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+	if _, err := parseAuditTime("yesterday", now); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAuditCommand(t *testing.T) {
+	tt := testtier(t, okHandler(t))
+	if err := os.WriteFile("audit.log", []byte(`{"time":"2026-01-01T00:00:00Z","caller":"billing-worker","method":"POST","route":"/v1/subscribe","org":"org:acme","summary":"phase: plan:pro@2","status":200}
+{"time":"2026-01-02T00:00:00Z","token":"a1b2c3d4e5f6","method":"POST","route":"/v1/checkout","org":"org:blue","summary":"features: plan:pro@2","status":200}
+{"time":"2026-01-03T00:00:00Z","token":"a1b2c3d4e5f6","method":"POST","route":"/v1/push","summary":"plans: plan:pro@2","status":200}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt.Run("audit", "-org", "org:acme", "audit.log")
+	tt.GrepStdout(`^2026-01-01T00:00:00Z\s+billing-worker\s+org:acme\s+POST /v1/subscribe\s+200\s+phase: plan:pro@2$`, "expected subscribe entry")
+	tt.GrepBothNot(`org:blue`, "unexpected entry for other org")
+
+	tt.Run("audit", "-since", "2026-01-02T00:00:00Z", "-until", "2026-01-03T00:00:00Z", "audit.log")
+	tt.GrepStdout(`^2026-01-02T00:00:00Z\s+token:a1b2c3d4e5f6\s+org:blue\s+POST /v1/checkout`, "expected checkout entry")
+	tt.GrepBothNot(`/v1/push|/v1/subscribe`, "unexpected entries outside range")
+
+	tt.Run("audit", "-format", "json", "audit.log")
+	tt.GrepStdout(`"route":"/v1/push"`, "expected JSON lines")
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"tier.run/api/materialize"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+// A backup is the state of an account written by "tier export" and read by
+// "tier import".
+type backup struct {
+	Exported time.Time       `json:"exported"`
+	Pricing  json.RawMessage `json:"pricing"`
+	Orgs     []backupOrg     `json:"orgs"`
+}
+
+type backupOrg struct {
+	Org         string            `json:"org"`
+	Email       string            `json:"email,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Phone       string            `json:"phone,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Phases      []backupPhase     `json:"phases,omitempty"`
+}
+
+type backupPhase struct {
+	Effective time.Time          `json:"effective"`
+	Features  []refs.FeaturePlan `json:"features"`
+	Trial     bool               `json:"trial,omitempty"`
+}
+
+// exportBackup returns the pricing model, orgs, and phases in the account
+// used by c. Customers without an org are skipped.
+func exportBackup(ctx context.Context, c *control.Client) (*backup, error) {
+	m, err := c.Pull(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	pricing, err := materialize.ToPricingJSON(m)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := c.ListOrgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		return orgs[i].ID < orgs[j].ID
+	})
+
+	b := &backup{
+		Exported: time.Now().UTC(),
+		Pricing:  pricing,
+	}
+	for _, o := range orgs {
+		if o.ID == "" {
+			continue
+		}
+		info, err := c.LookupOrg(ctx, o.ID)
+		if err != nil {
+			return nil, err
+		}
+		s, err := c.LookupPhases(ctx, o.ID)
+		if err != nil {
+			return nil, err
+		}
+		b.Orgs = append(b.Orgs, backupOrg{
+			Org:         o.ID,
+			Email:       info.Email,
+			Name:        info.Name,
+			Description: info.Description,
+			Phone:       info.Phone,
+			Metadata:    info.Metadata,
+			Phases:      backupPhases(s.Phases),
+		})
+	}
+	return b, nil
+}
+
+func backupPhases(ps []control.Phase) []backupPhase {
+	var bps []backupPhase
+	for _, p := range ps {
+		bps = append(bps, backupPhase{
+			Effective: p.Effective.UTC(),
+			Features:  p.Features,
+			Trial:     p.Trial,
+		})
+	}
+	return bps
+}
+
+// readBackup decodes the backup in r.
+func readBackup(r io.Reader) (*backup, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var b backup
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	for i, o := range b.Orgs {
+		if !strings.HasPrefix(o.Org, "org:") {
+			return nil, fmt.Errorf("backup: org %d: org must be prefixed with \"org:\"", i)
+		}
+	}
+	return &b, nil
+}
+
+// importBackup recreates the plans and orgs in b in the account used by c,
+// and subscribes each org to the phases in b that are not yet over. Plans
+// already in the account are left alone, as are orgs already subscribed to
+// the same phases, so importing a backup more than once is safe. If dryRun
+// is true, no changes are made. A line for each plan and org is written to w
+// reporting what was, or would be, done.
+func importBackup(ctx context.Context, c *control.Client, b *backup, dryRun bool, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	defer tw.Flush()
+
+	fs, err := materialize.FromPricingHuJSON(b.Pricing)
+	if err != nil {
+		return err
+	}
+	m, err := c.Pull(ctx, 0)
+	if err != nil {
+		return err
+	}
+	have := map[refs.Plan]bool{}
+	for _, f := range m {
+		have[f.Plan()] = true
+	}
+	var push []control.Feature
+	exists, missing := map[refs.Plan]bool{}, map[refs.Plan]bool{}
+	for _, f := range fs {
+		if have[f.Plan()] {
+			exists[f.Plan()] = true
+		} else {
+			push = append(push, f)
+			missing[f.Plan()] = true
+		}
+	}
+	if len(push) > 0 && !dryRun {
+		if err := c.Push(ctx, push, func(control.Feature, error) {}); err != nil {
+			return err
+		}
+	}
+	for _, p := range sortedPlans(exists) {
+		fmt.Fprintf(tw, "%s\texists\t\n", p)
+	}
+	for _, p := range sortedPlans(missing) {
+		fmt.Fprintf(tw, "%s\tpush\t\n", p)
+	}
+
+	now := time.Now()
+	for _, o := range b.Orgs {
+		want := pendingPhases(o.Phases, now)
+
+		action := "update"
+		var current []backupPhase
+		s, err := c.LookupPhases(ctx, o.Org)
+		switch {
+		case errors.Is(err, control.ErrOrgNotFound):
+			action = "create"
+		case err != nil:
+			return err
+		default:
+			current = pendingPhases(backupPhases(s.Phases), now)
+			if samePhases(current, want) {
+				action = "unchanged"
+			}
+		}
+
+		if !dryRun && action != "unchanged" {
+			if err := c.PutCustomer(ctx, o.Org, &control.OrgInfo{
+				Email:       o.Email,
+				Name:        o.Name,
+				Description: o.Description,
+				Phone:       o.Phone,
+				Metadata:    o.Metadata,
+			}); err != nil {
+				return err
+			}
+			var ps []control.Phase
+			for _, p := range want {
+				ps = append(ps, control.Phase{
+					Effective: p.Effective,
+					Features:  p.Features,
+					Trial:     p.Trial,
+				})
+			}
+			if len(ps) == 0 && len(current) > 0 {
+				ps = []control.Phase{{}} // cancel now
+			}
+			if len(ps) > 0 {
+				if err := c.Schedule(ctx, o.Org, control.ScheduleParams{Phases: ps}); err != nil {
+					return err
+				}
+			}
+		}
+
+		var features []string
+		if len(want) > 0 {
+			for _, fp := range want[0].Features {
+				features = append(features, fp.String())
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", o.Org, action, strings.Join(features, " "))
+	}
+	if dryRun {
+		fmt.Fprintln(tw, "\ndry run; no changes made")
+	}
+	return nil
+}
+
+func sortedPlans(plans map[refs.Plan]bool) []refs.Plan {
+	var ps []refs.Plan
+	for p := range plans {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].String() < ps[j].String()
+	})
+	return ps
+}
+
+// pendingPhases returns the phases in ps that are in effect at now or later.
+// The phase in effect at now is returned with a zero Effective time, meaning
+// it starts immediately. A leading phase with no features is dropped since
+// it only marks the org as not subscribed.
+func pendingPhases(ps []backupPhase, now time.Time) []backupPhase {
+	if len(ps) == 0 {
+		return nil
+	}
+	i := 0
+	for j, p := range ps {
+		if !p.Effective.After(now) {
+			i = j
+		}
+	}
+	pending := append([]backupPhase(nil), ps[i:]...)
+	if !pending[0].Effective.After(now) {
+		pending[0].Effective = time.Time{}
+	}
+	if len(pending[0].Features) == 0 {
+		pending = pending[1:]
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	return pending
+}
+
+// samePhases reports if a and b subscribe to the same features at the same
+// times, ignoring the order of features within a phase.
+func samePhases(a, b []backupPhase) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Effective.Equal(b[i].Effective) || a[i].Trial != b[i].Trial {
+			return false
+		}
+		if !sameFeatures(a[i].Features, b[i].Features) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFeatures(a, b []refs.FeaturePlan) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[refs.FeaturePlan]int{}
+	for _, fp := range a {
+		seen[fp]++
+	}
+	for _, fp := range b {
+		if seen[fp] == 0 {
+			return false
+		}
+		seen[fp]--
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/types/they"
+)
+
+func TestPendingPhases(t *testing.T) {
+	now := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	day := func(m, d int) time.Time {
+		return time.Date(2026, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	}
+	free := []refs.FeaturePlan{refs.MustParseFeaturePlan("feature:base@plan:free@1")}
+	pro := []refs.FeaturePlan{refs.MustParseFeaturePlan("feature:base@plan:pro@2")}
+
+	cases := []struct {
+		in   []backupPhase
+		want []backupPhase
+	}{
+		{nil, nil},
+		{
+			[]backupPhase{{Effective: day(1, 1), Features: free}},
+			[]backupPhase{{Features: free}},
+		},
+		{
+			[]backupPhase{
+				{Effective: day(1, 1), Features: free, Trial: true},
+				{Effective: day(1, 15), Features: pro},
+				{Effective: day(3, 1), Features: free},
+			},
+			[]backupPhase{
+				{Features: pro},
+				{Effective: day(3, 1), Features: free},
+			},
+		},
+		{
+			[]backupPhase{{Effective: day(3, 1), Features: pro}},
+			[]backupPhase{{Effective: day(3, 1), Features: pro}},
+		},
+		{
+			// cancelled
+			[]backupPhase{
+				{Effective: day(1, 1), Features: pro},
+				{Effective: day(1, 15)},
+			},
+			nil,
+		},
+	}
+	for _, tt := range cases {
+		got := pendingPhases(tt.in, now)
+		diff.Test(t, t.Errorf, got, tt.want)
+	}
+}
+
+func TestSamePhases(t *testing.T) {
+	a := refs.MustParseFeaturePlan("feature:a@plan:pro@2")
+	b := refs.MustParseFeaturePlan("feature:b@plan:pro@2")
+	later := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		x, y []backupPhase
+		want bool
+	}{
+		{nil, nil, true},
+		{
+			[]backupPhase{{Features: []refs.FeaturePlan{a, b}}},
+			[]backupPhase{{Features: []refs.FeaturePlan{b, a}}},
+			true,
+		},
+		{
+			[]backupPhase{{Features: []refs.FeaturePlan{a}}},
+			[]backupPhase{{Features: []refs.FeaturePlan{a, b}}},
+			false,
+		},
+		{
+			[]backupPhase{{Features: []refs.FeaturePlan{a}}},
+			[]backupPhase{{Features: []refs.FeaturePlan{a}, Trial: true}},
+			false,
+		},
+		{
+			[]backupPhase{{Features: []refs.FeaturePlan{a}}, {Effective: later, Features: []refs.FeaturePlan{b}}},
+			[]backupPhase{{Features: []refs.FeaturePlan{a}}, {Effective: later.Add(time.Hour), Features: []refs.FeaturePlan{b}}},
+			false,
+		},
+		{
+			[]backupPhase{{Features: []refs.FeaturePlan{a}}},
+			nil,
+			false,
+		},
+	}
+	for _, tt := range cases {
+		if got := samePhases(tt.x, tt.y); got != tt.want {
+			t.Errorf("samePhases(%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestReadBackup(t *testing.T) {
+	b, err := readBackup(strings.NewReader(`{
+		"pricing": {"plans": {}},
+		"orgs": [{"org": "org:a", "phases": [{"features": ["feature:x@plan:free@1"]}]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, b.Orgs[0].Phases[0].Features, []refs.FeaturePlan{
+		refs.MustParseFeaturePlan("feature:x@plan:free@1"),
+	})
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`{"orgs": [{"org": "a"}]}`, `org 0: org must be prefixed`},
+		{`{"customers": []}`, `unknown field "customers"`},
+	}
+	for _, tt := range cases {
+		_, err := readBackup(strings.NewReader(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("readBackup(%s) = %v, want error containing %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	tt := testtier(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/prices"):
+			io.WriteString(w, `{"data": [{
+				"metadata": {"tier.feature": "feature:base@plan:free@1"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "usage_type": "licensed"},
+				"billing_scheme": "per_unit",
+				"unit_amount_decimal": "0"
+			}]}`)
+		case they.Want(r, "GET", "/v1/customers(/search)?"):
+			io.WriteString(w, `{"data": [{"id": "cus_blue", "metadata": {"tier.org": "org:blue"}}]}`)
+		case they.Want(r, "GET", "/v1/subscriptions"):
+			io.WriteString(w, `{"data": []}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	if err := os.WriteFile("state.json", []byte(`{
+		"pricing": {"plans": {
+			"plan:free@1": {"features": {"feature:base": {}}},
+			"plan:pro@2": {"features": {"feature:base": {"base": 4900}}}
+		}},
+		"orgs": [
+			{"org": "org:acme", "phases": [{"effective": "2026-01-01T00:00:00Z", "features": ["feature:base@plan:pro@2"]}]},
+			{"org": "org:blue"}
+		]
+	}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt.Run("import", "--dry-run", "state.json")
+	tt.GrepStdout(`^plan:free@1\s+exists\s*$`, "expected existing plan")
+	tt.GrepStdout(`^plan:pro@2\s+push\s*$`, "expected plan to push")
+	tt.GrepStdout(`^org:acme\s+create\s+feature:base@plan:pro@2$`, "expected org to create")
+	tt.GrepStdout(`^org:blue\s+unchanged\s*$`, "expected org unchanged")
+	tt.GrepStdout(`^dry run; no changes made$`, "expected dry run note")
+}
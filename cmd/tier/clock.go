@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+)
+
+// runClock runs the clock subcommand in args[0] with the remaining args.
+func runClock(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "new":
+		fs := flag.NewFlagSet("clock new", flag.ExitOnError)
+		name := fs.String("name", "tier", "the name of the clock")
+		start := fs.String("start", "", "the start time of the clock in RFC 3339 format (default is now)")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 0 {
+			return errUsage
+		}
+		t := time.Now()
+		if *start != "" {
+			var err error
+			t, err = time.Parse(time.RFC3339, *start)
+			if err != nil {
+				return fmt.Errorf("invalid start time: %w", err)
+			}
+		}
+		cr, err := tc().NewClock(ctx, *name, t)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, cr.ID)
+		return nil
+	case "advance":
+		var id, to string
+		switch len(args) {
+		case 1:
+			id, to = *flagClock, args[0]
+		case 2:
+			id, to = args[0], args[1]
+		}
+		if id == "" || to == "" {
+			return errUsage
+		}
+		cr, err := tc().LookupClock(ctx, id)
+		if err != nil {
+			return err
+		}
+		t, err := parseAdvance(cr.Present, to)
+		if err != nil {
+			return err
+		}
+		if err := tc().Advance(tier.WithClock(ctx, id), t); err != nil {
+			return err
+		}
+		cr, err = tc().LookupClock(ctx, id)
+		if err != nil {
+			return err
+		}
+		printClock(cr)
+		return nil
+	case "status":
+		id, err := clockArg(args)
+		if err != nil {
+			return err
+		}
+		cr, err := tc().LookupClock(ctx, id)
+		if err != nil {
+			return err
+		}
+		printClock(cr)
+		return nil
+	case "ls":
+		if len(args) != 0 {
+			return errUsage
+		}
+		cr, err := tc().ListClocks(ctx)
+		if err != nil {
+			return err
+		}
+		tw := newTabWriter()
+		defer tw.Flush()
+		fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tPRESENT")
+		for _, c := range cr.Clocks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+				c.ID,
+				c.Name,
+				c.Status,
+				c.Present.UTC().Format(time.RFC3339),
+			)
+		}
+		return nil
+	case "rm":
+		ids := args
+		if len(ids) == 0 && *flagClock != "" {
+			ids = []string{*flagClock}
+		}
+		if len(ids) == 0 {
+			return errUsage
+		}
+		for _, id := range ids {
+			if err := tc().DeleteClock(ctx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+// clockArg returns the clock ID in args, or the -clock flag if args is empty.
+func clockArg(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		if *flagClock != "" {
+			return *flagClock, nil
+		}
+	case 1:
+		return args[0], nil
+	}
+	return "", errUsage
+}
+
+// parseAdvance parses s as a time to advance a clock at present to. The time
+// may be in RFC 3339 format, or relative to present as a number of days such
+// as "31d", or as a duration such as "36h".
+func parseAdvance(present time.Time, s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if days, ok := strings.CutSuffix(s, "d"); ok {
+			n, err := strconv.Atoi(days)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid number of days: %q", s)
+			}
+			t = present.AddDate(0, 0, n)
+		} else {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid time or duration: %q", s)
+			}
+			t = present.Add(d)
+		}
+	}
+	if !t.After(present) {
+		return time.Time{}, errors.New("clocks may only be advanced forward")
+	}
+	return t, nil
+}
+
+func printClock(cr apitypes.ClockResponse) {
+	tw := newTabWriter()
+	defer tw.Flush()
+	fmt.Fprintf(tw, "ID\t%s\n", cr.ID)
+	fmt.Fprintf(tw, "NAME\t%s\n", cr.Name)
+	fmt.Fprintf(tw, "STATUS\t%s\n", cr.Status)
+	fmt.Fprintf(tw, "PRESENT\t%s\n", cr.Present.UTC().Format(time.RFC3339))
+	fmt.Fprintf(tw, "LINK\t%s\n", cr.Link)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"tier.run/types/they"
+)
+
+func TestParseAdvance(t *testing.T) {
+	present := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "2026-03-01T00:00:00Z", want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "1d", want: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "36h", want: time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{in: "0d", wantErr: true},
+		{in: "-1h", wantErr: true},
+		{in: "2026-01-01T00:00:00Z", wantErr: true},
+		{in: "xd", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+	for _, tt := range cases {
+		got, err := parseAdvance(present, tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAdvance(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseAdvance(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClockCommands(t *testing.T) {
+	var gotTestClock string
+	tt := testtier(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "POST", "/v1/test_helpers/test_clocks"):
+			io.WriteString(w, `{"id": "clock_new", "name": "tier", "status": "ready", "frozen_time": 1767225600}`)
+		case they.Want(r, "GET", "/v1/test_helpers/test_clocks"):
+			io.WriteString(w, `{"data": [
+				{"id": "clock_1", "name": "a", "status": "ready", "frozen_time": 1767225600}
+			]}`)
+		case they.Want(r, "GET", "/v1/test_helpers/test_clocks/clock_1"):
+			io.WriteString(w, `{"id": "clock_1", "name": "a", "status": "ready", "frozen_time": 1767225600}`)
+		case they.Want(r, "POST", "/v1/test_helpers/test_clocks/clock_1/advance"):
+			io.WriteString(w, `{"id": "clock_1", "name": "a", "status": "advancing", "frozen_time": 1769904000}`)
+		case they.Want(r, "DELETE", "/v1/test_helpers/test_clocks/clock_1"):
+			io.WriteString(w, `{"deleted": true}`)
+		case they.Want(r, "GET", "/v1/customers"):
+			// the Stripe client sends GET parameters in the body
+			body, _ := io.ReadAll(r.Body)
+			q, _ := url.ParseQuery(string(body))
+			gotTestClock = q.Get("test_clock")
+			io.WriteString(w, `{"data": [{"id": "cus_1", "metadata": {"tier.org": "org:a"}}]}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	tt.Run("clock", "new", "--start", "2026-01-01T00:00:00Z")
+	tt.GrepStdout(`^clock_new$`, "expected clock ID")
+
+	tt.Run("clock", "ls")
+	tt.GrepStdout(`^clock_1\s+a\s+ready\s+2026-01-01T00:00:00Z$`, "expected clock listed")
+
+	tt.Run("clock", "status", "clock_1")
+	tt.GrepStdout(`^STATUS\s+ready$`, "expected status")
+
+	tt.Run("-clock", "clock_1", "clock", "status")
+	tt.GrepStdout(`^ID\s+clock_1$`, "expected clock from flag")
+
+	tt.Run("clock", "advance", "clock_1", "31d")
+	tt.GrepStdout(`^ID\s+clock_1$`, "expected clock status after advance")
+
+	tt.RunFail("clock", "advance", "clock_1", "-1h")
+	tt.GrepStderr("only be advanced forward", "expected error")
+
+	tt.Run("clock", "rm", "clock_1")
+
+	tt.RunFail("clock", "status")
+	tt.GrepStderr("Usage:", "expected usage")
+
+	tt.Run("-clock", "clock_1", "whois", "org:a")
+	tt.GrepStdout(`cus_1`, "expected customer ID")
+	if gotTestClock != "clock_1" {
+		t.Errorf("test_clock = %q, want %q", gotTestClock, "clock_1")
+	}
+}
@@ -15,7 +15,9 @@ import (
 	"tier.run/profile"
 )
 
-func connect() error {
+// connect creates Stripe restricted keys and saves them in the profile with
+// the provided name.
+func connect(name string) error {
 	ctx := context.Background()
 
 	deviceName, err := os.Hostname()
@@ -47,9 +49,12 @@ func connect() error {
 		return err
 	}
 
-	if err := profile.Save("tier", p); err != nil {
+	if err := profile.Save(name, p); err != nil {
 		return err
 	}
+	if name != profileName() {
+		fmt.Fprintf(stdout, "Saved as profile %q. Use it with 'tier -profile %s', or make it the default with 'tier profiles use %s'.\n\n", name, name, name)
+	}
 
 	// TODO(bmizerany): check whoami to verify keys are correct
 
@@ -108,15 +113,16 @@ func fetchProfile(ctx context.Context, pollURL string) (*profile.Profile, error)
 }
 
 // getKey returns the API from the environment variable STRIPE_API_KEY, or the
-// live mode key in config.json, or the test mode key in config.json, in that
-// order. It returns an error if no key is found.
+// live mode key of the active profile in config.json, or its test mode key,
+// in that order. It returns an error if no key is found.
 func getKey() (key, source string, err error) {
 	if envAPIKey != "" {
 		return envAPIKey, "STRIPE_API_KEY", nil
 	}
-	p, err := profile.Load("tier")
+	name := profileName()
+	p, err := profile.Load(name)
 	if err != nil {
-		return "", "", err
+		return "", "", fmt.Errorf("profile %q: %w", name, err)
 	}
 	if *flagLive {
 		return p.LiveAPIKey, profile.ConfigPath(), nil
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"tier.run/api/materialize"
+	"tier.run/control"
+)
+
+// runDoctor runs the doctor subcommand in args[0] with the remaining args.
+func runDoctor(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "drift":
+		fs := flag.NewFlagSet("doctor drift", flag.ExitOnError)
+		format := fs.String("format", "", "pricing format: json, yaml, or toml (default is by file extension, or json)")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		name := "pricing.json"
+		switch fs.NArg() {
+		case 0:
+		case 1:
+			name = fs.Arg(0)
+		default:
+			return errUsage
+		}
+		pf := pricingFormat(name)
+		if *format != "" {
+			var err error
+			pf, err = materialize.ParseFormat(*format)
+			if err != nil {
+				return err
+			}
+		}
+
+		r, _, err := stdinRemoteOrFile(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		m, err := materialize.FromPricing(data, pf)
+		if err != nil {
+			return err
+		}
+		ds, err := cc().Drift(ctx, m)
+		if err != nil {
+			return err
+		}
+		if len(ds) == 0 {
+			fmt.Fprintln(stdout, "no drift found")
+			return nil
+		}
+		if err := printDrift(stdout, ds); err != nil {
+			return err
+		}
+		if len(ds) == 1 {
+			return errors.New("1 difference found")
+		}
+		return fmt.Errorf("%d differences found", len(ds))
+	default:
+		return errUsage
+	}
+}
+
+func printDrift(w io.Writer, ds []control.Drift) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSTRIPE ID\tFIELD\tWANT\tGOT")
+	for _, d := range ds {
+		var name string
+		switch {
+		case !d.Feature.IsZero():
+			name = d.Feature.String()
+		case !d.Plan.IsZero():
+			name = d.Plan.String()
+		}
+		want, got := "-", "-"
+		if d.Field != "" {
+			want, got = fmt.Sprintf("%q", d.Want), fmt.Sprintf("%q", d.Got)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.Kind,
+			dashIfEmpty(name),
+			dashIfEmpty(d.ProviderID),
+			dashIfEmpty(d.Field),
+			want,
+			got,
+		)
+	}
+	return tw.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"tier.run/types/they"
+)
+
+func TestDoctorDrift(t *testing.T) {
+	tt := testtier(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case they.Want(r, "GET", "/v1/prices"):
+			io.WriteString(w, `{"data": [{
+				"id": "price_base",
+				"active": true,
+				"lookup_key": "tier__feature-base-plan-pro-2",
+				"metadata": {"tier.feature": "feature:base@plan:pro@2", "tier.plan_title": "Pro", "tier.title": "Platform"},
+				"product": {"id": "tier__feature-base-plan-pro-2", "name": "Pro - Platform", "active": true}
+			}]}`)
+		case they.Want(r, "GET", "/v1/products"):
+			io.WriteString(w, `{"data": [{"id": "tier__plan-pro-2"}]}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(999)
+			io.WriteString(w, `{}`)
+		}
+	})
+
+	if err := os.WriteFile("pricing.json", []byte(`{"plans": {
+		"plan:pro@2": {"title": "Pro", "features": {"feature:base": {"title": "Base", "base": 4900}}}
+	}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("other.yaml", []byte(`plans:
+  plan:pro@2:
+    title: Pro
+    features:
+      feature:base:
+        title: Platform
+        base: 4900
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt.RunFail("doctor", "drift")
+	tt.GrepStdout(`^edited\s+feature:base@plan:pro@2\s+price_base\s+metadata\[tier.title\]\s+"Base"\s+"Platform"$`, "expected edited title")
+	tt.GrepStdout(`^edited\s+feature:base@plan:pro@2\s+price_base\s+product.name\s+"Pro - Base"\s+"Pro - Platform"$`, "expected edited product name")
+	tt.GrepStderr(`2 differences found`, "expected count")
+
+	tt.Run("doctor", "drift", "other.yaml")
+	tt.GrepStdout(`^no drift found$`, "expected no drift")
+
+	tt.RunFail("doctor", "lint")
+	tt.GrepStderr("Usage:", "expected usage")
+}
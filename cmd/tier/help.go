@@ -17,8 +17,11 @@ var (
 
 The commands are:
 
+	init       create a starter pricing model for a new project
 	connect    connect your Stripe account
+	profiles   list, select, and remove saved Stripe accounts
 	push       push pricing plans to Stripe
+	validate   check pricing plans for problems
 	pull       pull pricing plans from Stripe
 	ls         list pricing plans
 	version    display the current CLI version
@@ -26,6 +29,14 @@ The commands are:
 	phases     list scheduled phases for an org
 	limits     list feature limits for an org
 	report     report usage for metered features
+	quote      compute the cost of plans for an amount of usage
+	simulate   play out a billing scenario using a test clock
+	clock      create, advance, list, and remove test clocks
+	revenue    report monthly recurring revenue by plan
+	doctor     check Stripe for changes made outside of Tier
+	audit      show who changed orgs and plans through the sidecar
+	export     write orgs, subscriptions, and plans to a backup
+	import     restore orgs, subscriptions, and plans from a backup
 	whoami     display the current account information
 	switch     create and switch to clean rooms
 	whois      display the Stripe customer ID for an org
@@ -36,6 +47,8 @@ The commands are:
 The flags are:
 
 	-live      use live Stripe key (default is false)
+	-clock     run commands against the test clock with the provided ID
+	-profile   use the named profile from "tier connect"
 	-v         verbose output
 	-h         show this message
 
@@ -46,6 +59,18 @@ Environment variables:
 	  Stripe API key. If not set, the CLI will use
 	  $HOME/.config/tier/config.json if present; otherwise an error will
 	  occur.
+
+	TIER_PROFILE
+
+	  The name of the profile in $HOME/.config/tier/config.json to use,
+	  unless -profile is set. If neither is set, the profile selected with
+	  "tier profiles use" is used, or else the profile named "tier".
+
+	TIER_CONFIG_PASSPHRASE, TIER_CONFIG_KEY_FILE
+
+	  The passphrase, or the path to a file holding the key, used to
+	  decrypt the API keys in $HOME/.config/tier/config.json once they are
+	  encrypted with "tier profiles encrypt".
 `)
 )
 
@@ -57,9 +82,42 @@ var topics = map[string]string{
 Print the version of the Tier CLI.
 `,
 
+	"init": `Usage:
+
+	tier init [flags]
+
+Tier init starts a new project by writing a starter pricing model, built from
+a template, to pricing.json. It then adds tier.state to .gitignore, so that
+the isolated account used by "tier switch" is not shared by accident.
+
+Without --template, init asks which template to use, and whether to create
+an isolated test account and push the plans to it.
+
+The templates are:
+
+	free-pro-enterprise
+		free, pro, and enterprise plans with limits and overages
+	seats
+		per-seat team and business plans
+	usage
+		pay-as-you-go usage pricing
+
+Flags:
+
+    --template <name>
+	The template to start from.
+    --o <file>
+	The file to write the pricing model to. The default is pricing.json.
+    --f
+	Overwrite the file if it exists.
+    --push
+	Create an isolated test account with "tier switch -c" and push the
+	pricing model to it.
+`,
+
 	"push": `Usage:
 
-	tier [--live] push <filename | url | - >
+	tier [--live] push [--format json|yaml|toml] <filename | url | - >
 
 "tier push" pushes pricing JSON to Stripe. The data may come from a file, url,
 or stdin. If a URL is specified, push will use the response body from a GET
@@ -67,28 +125,64 @@ request as pricing JSON, if the status code is of the 2XX variety and the body
 is valid pricing JSON. If the filename is ("-") then the pricing JSON is read
 from stdin.
 
+Pricing may also be written in YAML or TOML, using the same structure as
+pricing JSON. Files and URLs ending in .yaml, .yml, or .toml are read as YAML
+or TOML respectively; all others are read as JSON. Use --format to override
+the detected format, such as when reading from stdin. YAML anchors, aliases,
+and merge keys may be used to share definitions between plans.
+
 To learn more about how this works, please visit: https://tier.run/docs/cli/push
 
 If the --live flag is provided, your accounts live mode will be used.
+`,
+
+	"validate": `Usage:
+
+	tier validate [--format json|yaml|toml] <filename | url | - >
+
+Tier validate checks the pricing JSON, YAML, or TOML for problems without
+pushing it to Stripe. Each problem is reported on its own line in the form:
+
+	pricing.json:12:7: plans["plan:free@0"].features["feature:x"].mode: message
+
+In addition to checking the pricing is well formed and has no unknown fields,
+validate checks that:
+
+	- plan and feature names are valid
+	- plans have at least one, and at most 20, features
+	- intervals, currencies, modes, aggregates, and roundings are known
+	- tiers are sorted by ascending upto, and only the last omits upto
+	- prices and bases are not negative
+	- divide.by is greater than zero
+
+The format is detected as with push. Positions are not reported for problems
+found in TOML, other than syntax errors.
+
+Tier push makes the same checks before pushing.
 `,
 
 	"pull": `Usage:
 
-	tier [--live] pull 
+	tier [--live] pull [--format json|yaml|toml]
 
-Tier pull pulls the pricing JSON from Stripe and writes it to stdout.
+Tier pull pulls the pricing JSON from Stripe and writes it to stdout. Use
+--format to write it as YAML or TOML instead.
 
 If the --live flag is provided, your accounts live mode will be used.
 `,
 
 	"connect": `Usage:
 
-	tier connect
+	tier connect [--profile <name>]
 
 Tier connect creates a set of Stripe restricted keys and writes them to
 ~/.config/tier/config.json for use with push, pull, and other commands that
 interact with Stripe.
 
+The keys are saved in the active profile, or in the profile named with
+--profile, so that more than one Stripe account can be connected. See "tier
+help profiles".
+
 In order to use the generated restricted key for live mode pushes, it must
 have additional permissions granted on the Stripe dashboard.
 
@@ -187,6 +281,248 @@ Flags:
 	At specifies the time at which the usage occurred. If not provided,
 	the current time will be used. The time must be in seconds since the
 	epoch.
+`,
+	"quote": `Usage:
+
+	tier [--live] quote <plan|featurePlan>... [feature=n]...
+
+Tier quote computes what an org would pay for one billing period of the
+provided plans and features, given n units of usage of each named metered
+feature. Metered features without usage are quoted for zero units. Licensed
+features are quoted at their base price.
+
+Quotes are computed from the pricing model in Stripe the way Stripe computes
+invoices, including tiers, volume pricing, and transforms, but nothing is
+created in Stripe. Taxes, coupons, and proration are not included.
+
+Example:
+
+	; tier quote plan:pro@2 feature:api=1200000
+	FEATURE                  QUANTITY  BILLED   AMOUNT
+	feature:api@plan:pro@2   1200000   1200000  1204.00
+	feature:base@plan:pro@2  1         1        49.00
+	TOTAL                                       1253.00 usd
+
+Amounts are shown in the major unit of the currency; for currencies without a
+minor unit, such as jpy, amounts are shown in whole units.
+
+If the --live flag is provided, your accounts live mode will be used.
+`,
+	"simulate": `Usage:
+
+	tier simulate <filename | url | - >
+
+Tier simulate plays out a billing scenario in Stripe Test Mode using a new
+test clock, and then prints the invoices Stripe created for each org in the
+scenario. It is useful for checking a pricing model end-to-end before going
+live.
+
+A scenario is a JSON object with an optional start time for the clock, which
+defaults to now, and a list of steps. Each step subscribes an org to plans or
+features, reports usage, or advances the clock. After each advance, simulate
+waits for Stripe to finish advancing the clock before continuing. Usage is
+reported at the clock's current time.
+
+Example scenario:
+
+	{
+	  "start": "2026-01-01T00:00:00Z",
+	  "steps": [
+	    {"subscribe": {
+	      "org": "org:acme",
+	      "features": ["plan:pro@2"],
+	      "payment_method": "pm_card_us",
+	      "trial_days": 14
+	    }},
+	    {"report": {"org": "org:acme", "feature": "feature:api", "n": 1200}},
+	    {"advance": {"days": 20}},
+	    {"report": {"org": "org:acme", "feature": "feature:api", "n": 800}},
+	    {"advance": {"to": "2026-03-01T00:00:00Z"}}
+	  ]
+	}
+
+Advance steps take a number of days and hours to advance by, or a time to
+advance to. Subscribe steps may set trial_days as with "tier subscribe", and a
+payment_method to set as the org's default.
+
+Orgs are created under the test clock, and so are not visible outside of the
+simulation. Stripe allows at most 3 orgs per test clock.
+
+The plans and features must already be pushed. Simulate is not available in
+live mode.
+
+The output is in the format:
+
+	ORG       PERIOD      FEATURE                  QUANTITY  AMOUNT
+	org:acme  2026-01-01  feature:api@plan:pro@2   0         0.00
+	org:acme  2026-01-01  TOTAL                              0.00 usd
+	org:acme  2026-01-15  feature:api@plan:pro@2   2000      20.00
+	org:acme  2026-01-15  TOTAL                              20.00 usd
+`,
+	"clock": `Usage:
+
+	tier clock new [--name <name>] [--start <time>]
+	tier clock advance [clockID] <time | duration>
+	tier clock status [clockID]
+	tier clock ls
+	tier clock rm [clockID]...
+
+Tier clock manages Stripe test clocks, which simulate the passing of time for
+the orgs and subscriptions created under them.
+
+"tier clock new" creates a clock starting at the provided time, in RFC 3339
+format, or now, and prints its ID.
+
+"tier clock advance" advances a clock to the provided time, in RFC 3339
+format, or by a number of days such as "31d", or by a duration such as
+"36h". It waits for Stripe to finish advancing the clock, and then prints the
+clock's status.
+
+"tier clock status" prints the status of a clock. "tier clock ls" lists all
+clocks, newest first. "tier clock rm" removes clocks, along with all orgs and
+subscriptions created under them.
+
+Advance, status, and rm use the clock set with the global --clock flag if no
+clock ID is provided. With --clock, other commands such as subscribe, report,
+limits, and phases run against the clock, creating orgs under it as needed:
+
+	; clock=$(tier clock new --start 2026-01-01T00:00:00Z)
+	; tier --clock $clock subscribe org:acme plan:pro@2
+	; tier --clock $clock report org:acme feature:api 1200
+	; tier --clock $clock clock advance 31d
+	; tier --clock $clock limits org:acme
+	; tier clock rm $clock
+
+Clocks are only available in Stripe Test Mode.
+`,
+	"revenue": `Usage:
+
+	tier [--live] revenue [--format <table|csv|json>]
+
+Tier revenue reports the monthly recurring revenue (MRR) of each plan, by
+currency, from the active and past due subscriptions created by Tier.
+Subscriptions in a trial are not counted.
+
+Licensed features are counted at their price and quantity. Metered features
+are estimated from the most recent closed invoice of each subscription. Both
+are normalized to a month from the billing interval of their price, so a
+yearly price of 120.00 counts as 10.00. Discounts and taxes are not included.
+
+Amounts are in the currency's major unit, except with --format=json, where
+they are in its smallest unit, such as cents.
+
+The output is in the format:
+
+	PLAN         CURRENCY  ORGS  LICENSED  METERED  MRR
+	plan:pro@2   usd       12    588.00    51.20    639.20
+	plan:team@1  eur       3     30.00     0.00     30.00
+	TOTAL        usd                                639.20
+	TOTAL        eur                                30.00
+`,
+	"audit": `Usage:
+
+	tier audit [--org <org>] [--since <time>] [--until <time>] [--format <table|json>] <filename | - >
+
+Tier audit shows the entries in an audit log written by "tier serve
+--audit-log". The sidecar records each request that changes state in Stripe:
+subscribing and checking out orgs, updating their info, reporting usage with
+clobber, pushing pricing models, and creating, advancing, and removing test
+clocks. Plain usage reports are not recorded.
+
+Each entry records when the request was made, who made it, the org it was for,
+a summary of the request, the IDs of the requests made to Stripe while serving
+it, and the response status and error, if any.
+
+Callers are identified by a fingerprint of the token they used, and by the
+name they send in the Tier-Caller header, if any. The name is not
+authenticated. Clients using the Tier SDKs set the header with Client.Caller.
+
+The flags are:
+
+	--org     only show entries for the org
+	--since   only show entries at or after a time
+	--until   only show entries before a time
+	--format  table (the default) or json, which writes entries as JSON lines
+
+Times may be given as an RFC 3339 time such as 2026-01-02T15:04:05Z, a date
+such as 2026-01-02 in the local time zone, or a duration before now such as
+24h.
+
+The output is in the format:
+
+	TIME                  CALLER          ORG       ROUTE               STATUS  SUMMARY
+	2026-01-02T15:04:05Z  billing-worker  org:acme  POST /v1/subscribe  200     phase: plan:pro@2
+`,
+	"doctor": `Usage:
+
+	tier [--live] doctor drift [--format <format>] [filename | url | - ]
+
+Tier doctor drift compares a pricing model, pricing.json by default, with the
+prices and products in Stripe, and reports changes made outside of Tier, such
+as in the Stripe dashboard. It exits with a non-zero status if any are found.
+
+It reports:
+
+	edited           price metadata, lookup keys, or product names that
+	                 no longer match the pricing model
+	inactive         prices or products for features in the model that
+	                 have been archived
+	missing_plan     plans without the hidden product "tier push" creates
+	                 for each plan
+	missing_feature  features in the model without a price
+	unknown_feature  prices for features not in the model, but in a plan
+	                 that is
+	untagged         prices without tier.feature metadata that use a Tier
+	                 lookup key
+
+Prices can not be changed in Stripe once created, apart from their metadata,
+lookup key, and active flag, so amounts and tiers are not compared.
+
+The output is in the format:
+
+	KIND      NAME                     STRIPE ID     FIELD                 WANT    GOT
+	edited    feature:api@plan:pro@2   price_1Mz...  metadata[tier.limit]  "1000"  "2000"
+	inactive  feature:base@plan:pro@2  price_1Mx...  active                "true"  "false"
+`,
+	"export": `Usage:
+
+	tier [--live] export
+
+Tier export writes a backup of the account to stdout as JSON. The backup
+holds the pricing model, as with "tier pull", and each org with its email,
+name, description, phone, metadata, and scheduled phases. Customers without
+an org are skipped.
+
+Payment methods are not exported. They belong to the Stripe account and can
+not be moved to another.
+
+Example:
+
+	; tier export > state.json
+`,
+	"import": `Usage:
+
+	tier [--live] import [--dry-run] <filename | url | - >
+
+Tier import restores a backup written by "tier export". Plans in the backup
+that are not in the account are pushed, and then each org is created, if
+needed, and subscribed to the phases in the backup that are not yet over.
+The phase in effect when the backup was taken starts immediately.
+
+Plans already in the account are left alone. Orgs already subscribed to the
+same phases are left alone, so importing the same backup more than once is
+safe. Orgs subscribed to other phases are resubscribed, and orgs with no
+phases left in the backup are cancelled.
+
+With --dry-run, import reports what it would do without making any changes.
+
+The output is in the format:
+
+	plan:free@1  exists
+	plan:pro@2   push
+	org:acme     create     feature:api@plan:pro@2 feature:base@plan:pro@2
+	org:blue     unchanged  feature:base@plan:free@1
+	org:gray     update     feature:base@plan:free@1
 `,
 	"whois": `Usage:
 
@@ -202,18 +538,147 @@ If the --live flag is provided, your accounts live mode will be used.
 
 Tier whoami reports Stripe account information associated with the current key
 in use as a result of "tier connect", "tier switch", or the STRIPE_API_KEY.
+When the key is from a profile, the name of the profile is reported too.
+`,
+
+	"profiles": `Usage:
+
+	tier profiles ls
+	tier profiles use <name>
+	tier profiles rm <name>
+	tier profiles show [name]
+	tier profiles encrypt
+	tier profiles decrypt
+
+Tier profiles manages the Stripe accounts saved by "tier connect" in
+~/.config/tier/config.json. Each profile is saved under a name, so that more
+than one account can be used from the same machine.
+
+The active profile is the one named with -profile, or in TIER_PROFILE, or
+else the one selected with "tier profiles use", or else the profile named
+"tier", which "tier connect" uses by default.
+
+Subcommands:
+
+	ls       list profiles; the active profile is marked with *
+	use      select the profile used when -profile and TIER_PROFILE are not set
+	rm       remove a profile and its keys
+	show     show a profile, the active profile by default, with keys redacted
+	encrypt  encrypt the API keys in the config file
+	decrypt  store the API keys in the config file unencrypted again
+
+The API keys in the config file may be encrypted with a key derived from the
+passphrase in TIER_CONFIG_PASSPHRASE, or from the contents of the file named
+in TIER_CONFIG_KEY_FILE, which must hold at least 32 bytes of random data. If
+both are set, the key file is used. The keys are encrypted in place by "tier
+profiles encrypt", and from then on the same variable must be set for tier to
+use or save the keys. Listing and selecting profiles does not need it.
+
+Examples:
+
+	; tier connect -profile acme
+	; tier profiles use acme
+	; tier -profile tier push pricing.json
+	; export TIER_CONFIG_PASSPHRASE='correct horse battery staple'
+	; tier profiles encrypt
 `,
 
 	`tier`: errUsage.Error(),
 
 	`serve`: `Usage:
 
-	tier serve [--addr <addr>]
+	tier serve [flags]
 
 Tier serve starts a web server that exposes the Tier API over HTTP listening on
 the provided service address.
 
-The default service address is "localhost:8080".
+The default service address is "localhost:8080". To listen on a Unix domain
+socket, use an address of the form "unix:///path/to/tier.sock". Clients using
+the Tier SDKs connect to the socket when TIER_BASE_URL is set to the same
+address.
+
+Requests must carry a bearer token in the Authorization header. Tokens are
+configured with the following environment variables, each holding a comma
+separated list of tokens. To rotate a token, add the new token, update
+clients, and then remove the old token.
+
+	TIER_SIDECAR_TOKENS
+	  Tokens allowed to use all routes.
+
+	TIER_SIDECAR_READONLY_TOKENS
+	  Tokens allowed to use only /v1/limits, /v1/phase, and /v1/whois.
+
+Clients using the Tier SDKs send the token set in TIER_SIDECAR_TOKEN.
+
+The routes /healthz and /readyz do not require a token and are intended for
+liveness and readiness probes. /healthz responds with 200 OK while the
+process is running. /readyz responds with 200 OK only if Stripe is reachable
+and accepts the configured API key, and with 503 Service Unavailable
+otherwise. Readiness results are cached for up to 30 seconds.
+
+A single sidecar may serve more than one Stripe account. Requests with a
+Tier-Account header holding the ID of a Stripe connected account are made on
+behalf of that account, provided it is listed with --accounts. Requests
+naming any other account are rejected. Clients using the Tier SDKs set the
+header with WithAccount.
+
+The pricing model used to subscribe, check out, and quote is cached for the
+duration set with --model-ttl, and fetched again after a push, or when
+/v1/refresh is called. To refresh it when prices or products are changed in
+the Stripe dashboard, add a Stripe webhook endpoint for the price.* and
+product.* events pointing at /v1/webhooks/stripe, and set its signing secret
+in TIER_WEBHOOK_SECRET. The webhook route does not require a token; events
+are verified by their signature instead.
+
+An OpenAPI 3 description of the API is served without a token at
+/v1/openapi.json.
+
+On SIGINT or SIGTERM, tier serve stops accepting new connections and waits
+for in-flight requests to finish before exiting.
+
+Flags:
+
+    --addr <addr>
+	The service address to listen on.
+    --insecure
+	Serve without requiring tokens. Anyone able to reach the service
+	address can manage subscriptions and pricing in your Stripe account.
+    --tls-cert <file>, --tls-key <file>
+	Serve HTTPS using the PEM encoded certificate and private key.
+    --tls-client-ca <file>
+	Require clients to present a certificate signed by one of the PEM
+	encoded CAs in file (mutual TLS). Requires --tls-cert and --tls-key.
+    --accounts <acct_1,acct_2,...>
+	The Stripe connected accounts requests may be made on behalf of.
+    --drain-timeout <duration>
+	How long to wait for in-flight requests to finish on shutdown before
+	closing their connections. The default is 30s.
+
+    --cache-size <n>
+	The number of org to Stripe customer IDs to keep in memory. Finding
+	the customer for an org not in the cache means searching for it in
+	Stripe. The default is 100.
+    --cache-ttl <duration>
+	How long to cache a customer ID. The default is until evicted.
+    --cache-negative-ttl <duration>
+	How long to remember that an org has no customer, such as 1m. The
+	default is not to remember, so that orgs created by other processes
+	are seen immediately.
+    --cache-dir <dir>
+	A directory to persist customer IDs in, so that they are not searched
+	for again after a restart. Use a separate directory for each Stripe
+	account. Requires --cache-ttl, which also limits how long customer
+	IDs are kept on disk.
+    --audit-log <file>
+	Append requests that change state in Stripe to the file as JSON lines.
+	See "tier help audit".
+    --model-ttl <duration>
+	How long to cache the pricing model. The default is 5m. Use 0 to
+	fetch it on each request.
+
+    --metrics
+	Serve request, Stripe, cache, and usage metrics in the Prometheus text
+	format at /metrics.
 `,
 	"switch": `Usage:
 
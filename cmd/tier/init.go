@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"tier.run/api/materialize"
+	"tier.run/profile"
+)
+
+//go:embed templates/*.json
+var templateFS embed.FS
+
+// pricingTemplates are the templates "tier init" may start a pricing model
+// from, in the order they are offered.
+var pricingTemplates = []struct {
+	name        string
+	description string
+}{
+	{"free-pro-enterprise", "free, pro, and enterprise plans with limits and overages"},
+	{"seats", "per-seat team and business plans"},
+	{"usage", "pay-as-you-go usage pricing"},
+}
+
+func pricingTemplate(name string) ([]byte, error) {
+	data, err := templateFS.ReadFile("templates/" + name + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		var names []string
+		for _, t := range pricingTemplates {
+			names = append(names, t.name)
+		}
+		return nil, fmt.Errorf("unknown template %q; must be one of %s", name, strings.Join(names, ", "))
+	}
+	return data, err
+}
+
+func initProject(ctx context.Context, p *profile.Profile, args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	template := fs.String("template", "", "the pricing template to start from; prompts if not set")
+	out := fs.String("o", "pricing.json", "the file to write the pricing model to")
+	force := fs.Bool("f", false, "overwrite the file if it exists")
+	push := fs.Bool("push", false, "create an isolated test account and push the pricing model to it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	name, doPush := *template, *push
+	if name == "" {
+		in := bufio.NewReader(stdin)
+		var err error
+		name, err = promptTemplate(in)
+		if err != nil {
+			return err
+		}
+		if !doPush {
+			doPush, err = promptYesNo(in, "Create an isolated test account and push the plans?")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := pricingTemplate(name)
+	if err != nil {
+		return err
+	}
+	if err := materialize.Validate(data, materialize.FormatJSON); err != nil {
+		return reportProblems(name, err) // should never happen; templates are tested
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(*out, flags, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists; use -f to overwrite it", *out)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "wrote %s from the %s template\n", *out, name)
+
+	added, err := ensureGitignore(".gitignore", stateFile)
+	if err != nil {
+		return err
+	}
+	if added {
+		fmt.Fprintf(stdout, "added %s to .gitignore\n", stateFile)
+	}
+
+	if !doPush {
+		fmt.Fprintf(stdout, "\nRun \"tier push -c %s\" to push the plans to a new isolated test account.\n", *out)
+		return nil
+	}
+	if cc().Live() {
+		return errors.New("init -push not allowed in live mode")
+	}
+	if err := switchAccounts(ctx, "-c"); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout)
+	fmt.Fprintln(stdout, "Pushing to new isolated account...")
+	controlClient = nil // reset the control client to use the new account
+	return pushFile(ctx, p, *out, materialize.FormatJSON)
+}
+
+// promptTemplate asks for the name of a pricing template, offering the first
+// as the default.
+func promptTemplate(in *bufio.Reader) (string, error) {
+	fmt.Fprintln(stdout, "Choose a pricing template:")
+	fmt.Fprintln(stdout)
+	tw := newTabWriter()
+	for i, t := range pricingTemplates {
+		fmt.Fprintf(tw, "  %d)\t%s\t%s\n", i+1, t.name, t.description)
+	}
+	tw.Flush()
+	fmt.Fprintln(stdout)
+	fmt.Fprint(stdout, "Template [1]: ")
+
+	answer, err := readAnswer(in)
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return pricingTemplates[0].name, nil
+	}
+	if n, err := strconv.Atoi(answer); err == nil {
+		if n < 1 || n > len(pricingTemplates) {
+			return "", fmt.Errorf("no template numbered %d", n)
+		}
+		return pricingTemplates[n-1].name, nil
+	}
+	return answer, nil
+}
+
+// promptYesNo asks question and reports if the answer is yes. The default is
+// no.
+func promptYesNo(in *bufio.Reader, question string) (bool, error) {
+	fmt.Fprintf(stdout, "%s [y/N]: ", question)
+	answer, err := readAnswer(in)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// readAnswer reads a line from in, treating the end of input as an empty
+// answer.
+func readAnswer(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ensureGitignore adds pattern to the .gitignore file at name, creating the
+// file if needed. It reports if pattern was added, and does nothing if the
+// file already ignores it.
+func ensureGitignore(name, pattern string) (added bool, err error) {
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == pattern || line == "/"+pattern {
+			return false, nil
+		}
+	}
+	var b strings.Builder
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	b.WriteString(pattern)
+	b.WriteByte('\n')
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.WriteString(f, b.String()); err != nil {
+		f.Close()
+		return false, err
+	}
+	return true, f.Close()
+}
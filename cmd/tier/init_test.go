@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"tier.run/api/materialize"
+)
+
+func TestPricingTemplates(t *testing.T) {
+	for _, tmpl := range pricingTemplates {
+		data, err := pricingTemplate(tmpl.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := materialize.Validate(data, materialize.FormatJSON); err != nil {
+			t.Errorf("%s: %v", tmpl.name, err)
+		}
+		if _, err := materialize.FromPricing(data, materialize.FormatJSON); err != nil {
+			t.Errorf("%s: %v", tmpl.name, err)
+		}
+	}
+	if _, err := pricingTemplate("nope"); err == nil {
+		t.Error("expected error for unknown template")
+	}
+}
+
+func TestEnsureGitignore(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	check := func(wantAdded bool, want string) {
+		t.Helper()
+		added, err := ensureGitignore(".gitignore", "tier.state")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != wantAdded {
+			t.Errorf("added = %v, want %v", added, wantAdded)
+		}
+		got, err := os.ReadFile(".gitignore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf(".gitignore = %q, want %q", got, want)
+		}
+	}
+
+	check(true, "tier.state\n")
+	check(false, "tier.state\n")
+
+	if err := os.WriteFile(".gitignore", []byte("node_modules"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check(true, "node_modules\ntier.state\n")
+
+	if err := os.WriteFile(".gitignore", []byte("/tier.state\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check(false, "/tier.state\n")
+}
+
+func TestInit(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+
+	tt.Run("init", "-template", "seats")
+	tt.GrepStdout("^wrote pricing.json from the seats template$", "expected file written")
+	tt.GrepStdout("^added tier.state to .gitignore$", "expected .gitignore updated")
+	tt.Run("validate", "pricing.json")
+
+	tt.RunFail("init", "-template", "seats")
+	tt.GrepStderr("pricing.json already exists", "expected overwrite error")
+
+	tt.Run("init", "-template", "usage", "-f")
+	tt.GrepStdoutNot("gitignore", "unexpected .gitignore update")
+
+	tt.SetStdinString("2\nn\n")
+	tt.Run("init", "-o", "other.json")
+	tt.GrepStdout("Choose a pricing template", "expected prompt")
+	tt.GrepStdout("wrote other.json from the seats template", "expected chosen template")
+
+	tt.SetStdinString("")
+	tt.Run("init", "-o", "default.json")
+	tt.GrepStdout("wrote default.json from the free-pro-enterprise template", "expected default template")
+
+	tt.RunFail("init", "-template", "nope", "-o", "nope.json")
+	tt.GrepStderr(`unknown template "nope"`, "expected unknown template error")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"tier.run/profile"
+)
+
+// profileName returns the name of the active profile: the one named with
+// -profile, or in TIER_PROFILE, or else the one selected with "tier
+// profiles use".
+func profileName() string {
+	if *flagProfile != "" {
+		return *flagProfile
+	}
+	if name := os.Getenv("TIER_PROFILE"); name != "" {
+		return name
+	}
+	c, err := profile.LoadConfig()
+	if err != nil {
+		vlogf("tier: %v", err)
+		return profile.DefaultName
+	}
+	return c.CurrentName()
+}
+
+// runProfiles runs the profiles subcommand in args[0] with the remaining
+// args.
+func runProfiles(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "ls":
+		if len(args) != 0 {
+			return errUsage
+		}
+		c, err := profile.LoadConfig()
+		if err != nil {
+			return err
+		}
+		active := profileName()
+		var names []string
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tw := newTabWriter()
+		fmt.Fprintln(tw, "\tNAME\tACCOUNT\tDISPLAY NAME")
+		for _, name := range names {
+			p := c.Profiles[name]
+			mark := ""
+			if name == active {
+				mark = "*"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mark, name, dashIfEmpty(p.AccountID), dashIfEmpty(p.DisplayName))
+		}
+		return tw.Flush()
+	case "use":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if err := profile.Use(args[0]); err != nil {
+			return profileErr(args[0], err)
+		}
+		if name := profileName(); name != args[0] {
+			fmt.Fprintf(stderr, "tier: note: profile %q is still active in this shell because of -profile or TIER_PROFILE\n", name)
+		}
+		return nil
+	case "rm":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return profileErr(args[0], profile.Remove(args[0]))
+	case "show":
+		var name string
+		switch len(args) {
+		case 0:
+			name = profileName()
+		case 1:
+			name = args[0]
+		default:
+			return errUsage
+		}
+		p, err := profile.Load(name)
+		if err != nil {
+			return profileErr(name, err)
+		}
+		tw := newTabWriter()
+		fmt.Fprintf(tw, "Name:\t%s\n", name)
+		fmt.Fprintf(tw, "Account:\t%s\n", dashIfEmpty(p.AccountID))
+		fmt.Fprintf(tw, "DisplayName:\t%s\n", dashIfEmpty(p.DisplayName))
+		fmt.Fprintf(tw, "TestKey:\t%s\n", redactKey(p.TestAPIKey))
+		fmt.Fprintf(tw, "LiveKey:\t%s\n", redactKey(p.LiveAPIKey))
+		fmt.Fprintf(tw, "Source:\t%s\n", profile.ConfigPath())
+		c, err := profile.LoadConfig()
+		if err != nil {
+			return err
+		}
+		encrypted := "no"
+		if c.Encryption != nil {
+			encrypted = "yes (" + c.Encryption.Method + ")"
+		}
+		fmt.Fprintf(tw, "Encrypted:\t%s\n", encrypted)
+		return tw.Flush()
+	case "encrypt":
+		if len(args) != 0 {
+			return errUsage
+		}
+		if os.Getenv("TIER_CONFIG_KEY_FILE") == "" && os.Getenv("TIER_CONFIG_PASSPHRASE") == "" {
+			return errors.New("set TIER_CONFIG_PASSPHRASE or TIER_CONFIG_KEY_FILE to the passphrase or key file to encrypt with")
+		}
+		return profile.Encrypt()
+	case "decrypt":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return profile.Decrypt()
+	default:
+		return errUsage
+	}
+}
+
+func profileErr(name string, err error) error {
+	if errors.Is(err, profile.ErrProfileNotFound) {
+		return fmt.Errorf("no profile named %q; see 'tier profiles ls'", name)
+	}
+	return err
+}
+
+// redactKey returns key with all but its prefix and last four characters
+// hidden, or "-" if key is empty.
+func redactKey(key string) string {
+	if key == "" {
+		return "-"
+	}
+	prefix := ""
+	for _, p := range []string{"sk_test_", "sk_live_", "rk_test_", "rk_live_"} {
+		if strings.HasPrefix(key, p) {
+			prefix = p
+			break
+		}
+	}
+	if len(key)-len(prefix) <= 8 {
+		return prefix + "..."
+	}
+	return prefix + "..." + key[len(key)-4:]
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"tier.run/profile"
+)
+
+func TestProfiles(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+	if err := profile.Save("work", &profile.Profile{
+		AccountID:   "acct_work",
+		DisplayName: "Work",
+		TestAPIKey:  "sk_test_0123456789abcdef",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tt.Run("profiles", "ls")
+	tt.GrepStdout(`^\*\s+tier\s+acct_profile\s+-$`, "expected default profile active")
+	tt.GrepStdout(`^\s+work\s+acct_work\s+Work$`, "expected work profile")
+
+	tt.Run("profiles", "use", "work")
+	tt.Run("profiles", "show")
+	tt.GrepStdout(`^Name:\s+work$`, "expected work profile shown")
+	tt.GrepStdout(`^TestKey:\s+sk_test_\.\.\.cdef$`, "expected redacted key")
+	tt.GrepBothNot(`0123456789`, "key not redacted")
+
+	tt.Run("-profile", "tier", "profiles", "ls")
+	tt.GrepStdout(`^\*\s+tier\s`, "expected -profile to select profile")
+
+	tt.Setenv("TIER_PROFILE", "tier")
+	tt.Run("profiles", "show")
+	tt.GrepStdout(`^Name:\s+tier$`, "expected TIER_PROFILE to select profile")
+	tt.Run("-profile", "work", "profiles", "show")
+	tt.GrepStdout(`^Name:\s+work$`, "expected -profile to override TIER_PROFILE")
+	tt.Unsetenv("TIER_PROFILE")
+
+	tt.RunFail("profiles", "use", "nope")
+	tt.GrepStderr(`no profile named "nope"`, "expected error for unknown profile")
+
+	tt.Run("profiles", "rm", "work")
+	tt.Run("profiles", "ls")
+	tt.GrepStdout(`^\*\s+tier\s`, "expected default profile active after removing current")
+	tt.GrepBothNot(`work`, "expected work profile removed")
+}
+
+func TestRedactKey(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"", "-"},
+		{"sk_test_0123456789abcdef", "sk_test_...cdef"},
+		{"rk_live_0123456789abcdef", "rk_live_...cdef"},
+		{"sk_test_short", "sk_test_..."},
+		{"0123456789abcdef", "...cdef"},
+	}
+	for _, tt := range cases {
+		if got := redactKey(tt.in); got != tt.want {
+			t.Errorf("redactKey(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestProfilesEncrypt(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+	if err := profile.Save("work", &profile.Profile{AccountID: "acct_work"}); err != nil {
+		t.Fatal(err)
+	}
+	tt.Unsetenv("TIER_CONFIG_PASSPHRASE")
+	tt.Unsetenv("TIER_CONFIG_KEY_FILE")
+
+	tt.RunFail("profiles", "encrypt")
+	tt.GrepStderr(`set TIER_CONFIG_PASSPHRASE or TIER_CONFIG_KEY_FILE`, "expected error without passphrase")
+
+	tt.Setenv("TIER_CONFIG_PASSPHRASE", "hunter2")
+	tt.Run("profiles", "encrypt")
+	tt.Run("profiles", "show")
+	tt.GrepStdout(`^Encrypted:\s+yes \(passphrase\)$`, "expected encrypted profile")
+	tt.GrepStdout(`^Account:\s+acct_profile$`, "expected profile to load")
+
+	tt.Unsetenv("TIER_CONFIG_PASSPHRASE")
+	tt.RunFail("profiles", "show")
+	tt.GrepStderr(`config is encrypted`, "expected error without passphrase")
+
+	// profiles are selected without the passphrase
+	tt.Run("profiles", "use", "work")
+	tt.RunFail("whoami")
+	tt.GrepStderr(`profile "work": config is encrypted`, "expected error for selected profile")
+	tt.Run("profiles", "use", "tier")
+
+	tt.Setenv("TIER_CONFIG_PASSPHRASE", "hunter2")
+	tt.Run("profiles", "decrypt")
+	tt.Unsetenv("TIER_CONFIG_PASSPHRASE")
+	tt.Run("profiles", "show")
+	tt.GrepStdout(`^Encrypted:\s+no$`, "expected decrypted profile")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"tier.run/api/apitypes"
+	"tier.run/control"
+)
+
+// printRevenue writes rr to w in format, which is one of "table", "csv", or
+// "json". Amounts are in the currency's smallest unit in JSON, and in its
+// major unit otherwise.
+func printRevenue(w io.Writer, format string, rr apitypes.RevenueResponse) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+		fmt.Fprintln(tw, "PLAN\tCURRENCY\tORGS\tLICENSED\tMETERED\tMRR")
+		var currencies []string
+		totals := map[string]int{}
+		for _, p := range rr.Plans {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+				p.Plan,
+				p.Currency,
+				p.Orgs,
+				control.FormatAmount(p.Licensed, p.Currency),
+				control.FormatAmount(p.Metered, p.Currency),
+				control.FormatAmount(p.MRR, p.Currency),
+			)
+			if _, ok := totals[p.Currency]; !ok {
+				currencies = append(currencies, p.Currency)
+			}
+			totals[p.Currency] += p.MRR
+		}
+		for _, c := range currencies {
+			fmt.Fprintf(tw, "TOTAL\t%s\t\t\t\t%s\n", c, control.FormatAmount(totals[c], c))
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"plan", "currency", "orgs", "licensed", "metered", "mrr"})
+		for _, p := range rr.Plans {
+			cw.Write([]string{
+				p.Plan.String(),
+				p.Currency,
+				strconv.Itoa(p.Orgs),
+				control.FormatAmount(p.Licensed, p.Currency),
+				control.FormatAmount(p.Metered, p.Currency),
+				control.FormatAmount(p.MRR, p.Currency),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		data, err := json.MarshalIndent(rr, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	default:
+		return fmt.Errorf("unknown format %q; must be table, csv, or json", format)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+)
+
+func TestPrintRevenue(t *testing.T) {
+	rr := apitypes.RevenueResponse{Plans: []apitypes.PlanRevenue{{
+		Plan:     refs.MustParsePlan("plan:pro@2"),
+		Currency: "usd",
+		Orgs:     2,
+		Licensed: 9800,
+		Metered:  4333,
+		MRR:      14133,
+	}, {
+		Plan:     refs.MustParsePlan("plan:team@1"),
+		Currency: "jpy",
+		Orgs:     1,
+		Licensed: 3000,
+		MRR:      3000,
+	}, {
+		Plan:     refs.MustParsePlan("plan:team@1"),
+		Currency: "usd",
+		Orgs:     1,
+		Licensed: 2000,
+		MRR:      2000,
+	}}}
+
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{"table", `PLAN         CURRENCY  ORGS  LICENSED  METERED  MRR
+plan:pro@2   usd       2     98.00     43.33    141.33
+plan:team@1  jpy       1     3000      0        3000
+plan:team@1  usd       1     20.00     0.00     20.00
+TOTAL        usd                                161.33
+TOTAL        jpy                                3000
+`},
+		{"csv", `plan,currency,orgs,licensed,metered,mrr
+plan:pro@2,usd,2,98.00,43.33,141.33
+plan:team@1,jpy,1,3000,0,3000
+plan:team@1,usd,1,20.00,0.00,20.00
+`},
+	}
+	for _, tt := range cases {
+		var b strings.Builder
+		if err := printRevenue(&b, tt.format, rr); err != nil {
+			t.Fatal(err)
+		}
+		diff.Test(t, t.Errorf, b.String(), tt.want)
+	}
+
+	var b strings.Builder
+	if err := printRevenue(&b, "json", rr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"mrr": 14133`) {
+		t.Errorf("json output missing mrr in smallest unit:\n%s", b.String())
+	}
+
+	if err := printRevenue(&b, "xml", rr); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
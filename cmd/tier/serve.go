@@ -1,28 +1,205 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"tier.run/api"
+	"tier.run/audit"
 	"tier.run/control"
+	"tier.run/metrics"
 	"tier.run/profile"
 	"tier.run/stripe"
 )
 
-func serve(addr string) error {
-	ln, err := net.Listen("tcp", addr)
+type serveConfig struct {
+	addr     string // host:port, or unix:///path/to/socket
+	metrics  bool   // serve Prometheus metrics at /metrics
+	insecure bool   // allow serving without tokens
+
+	// accounts are the Stripe connected accounts requests may be made on
+	// behalf of using the Tier-Account header.
+	accounts []string
+
+	// drainTimeout is how long to wait for in-flight requests to finish
+	// after receiving SIGINT or SIGTERM before closing their connections.
+	drainTimeout time.Duration
+
+	cache    control.CacheConfig
+	modelTTL time.Duration // how long to cache the pricing model
+	auditLog string        // if set, path to the audit log
+
+	tlsCert     string // path to PEM encoded certificate
+	tlsKey      string // path to PEM encoded private key
+	tlsClientCA string // if set, path to PEM encoded CAs used to verify required client certificates
+}
+
+func serve(sc serveConfig) error {
+	ln, err := listen(sc.addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	tc, err := sc.tlsConfig()
 	if err != nil {
 		return err
 	}
+	if tc != nil {
+		ln = tls.NewListener(ln, tc)
+	}
+
+	auth := authFromEnv()
+	if auth.Empty() && !sc.insecure {
+		return errors.New("no tokens configured; set TIER_SIDECAR_TOKENS or use -insecure to serve without authentication")
+	}
+
 	fmt.Fprintf(stdout, "listening on %s\n", ln.Addr())
 
-	h := api.NewHandler(cc(), vlogf)
-	return http.Serve(ln, h)
+	c := cc()
+	c.Cache = sc.cache
+	ah := api.NewHandler(c, vlogf)
+	if !auth.Empty() {
+		ah.Auth = auth
+	}
+	ah.Accounts = sc.accounts
+	ah.ModelTTL = sc.modelTTL
+	ah.WebhookSecret = os.Getenv("TIER_WEBHOOK_SECRET")
+	if sc.auditLog != "" {
+		f, err := audit.OpenFile(sc.auditLog)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		ah.Audit = f
+	}
+	var h http.Handler = ah
+	if sc.metrics {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/", h)
+		h = mux
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return runServer(ctx, &http.Server{Handler: h}, ln, sc.drainTimeout)
+}
+
+// runServer serves requests on ln using srv until ctx is done, then shuts
+// srv down, waiting up to drain for in-flight requests to finish. It returns
+// nil if all requests finished in time.
+func runServer(ctx context.Context, srv *http.Server, ln net.Listener, drain time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Fprintf(stderr, "tier: shutting down; draining requests for up to %v\n", drain)
+	sctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// listen listens on addr. If addr has the form unix://path, it listens on the
+// Unix domain socket at path, removing a stale socket left behind by a
+// previous process, if any; otherwise it listens on the TCP address addr.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("invalid unix socket address %q", addr)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s: socket in use", path)
+		}
+		vlogf("removing stale socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// tlsConfig returns the TLS configuration for sc, or nil if TLS is not
+// configured.
+func (sc *serveConfig) tlsConfig() (*tls.Config, error) {
+	if sc.tlsCert == "" && sc.tlsKey == "" {
+		if sc.tlsClientCA != "" {
+			return nil, errors.New("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if sc.tlsCert == "" || sc.tlsKey == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be used together")
+	}
+	cert, err := tls.LoadX509KeyPair(sc.tlsCert, sc.tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if sc.tlsClientCA != "" {
+		data, err := os.ReadFile(sc.tlsClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates found", sc.tlsClientCA)
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// authFromEnv returns the tokens configured in the TIER_SIDECAR_TOKENS and
+// TIER_SIDECAR_READONLY_TOKENS environment variables. Each may hold a comma
+// separated list of tokens to allow for rotation.
+func authFromEnv() *api.Auth {
+	return &api.Auth{
+		Tokens:         splitList(os.Getenv("TIER_SIDECAR_TOKENS")),
+		ReadOnlyTokens: splitList(os.Getenv("TIER_SIDECAR_READONLY_TOKENS")),
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
 }
 
 var controlClient *control.Client
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tier.sock")
+
+	ln, err := listen("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix://" + path); err == nil {
+		t.Error("expected error listening on socket in use")
+	}
+
+	// leave a stale socket behind
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln, err = listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("stale socket not removed: %v", err)
+	}
+	ln.Close()
+
+	if _, err := listen("unix://"); err == nil {
+		t.Error("expected error for empty socket path")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	bad := []serveConfig{
+		{tlsCert: certFile},
+		{tlsKey: keyFile},
+		{tlsClientCA: certFile},
+		{tlsCert: certFile, tlsKey: keyFile, tlsClientCA: keyFile},
+	}
+	for _, sc := range bad {
+		if _, err := sc.tlsConfig(); err == nil {
+			t.Errorf("tlsConfig(%+v): expected error", sc)
+		}
+	}
+
+	sc := serveConfig{}
+	c, err := sc.tlsConfig()
+	if err != nil || c != nil {
+		t.Errorf("tlsConfig() = %v, %v; want nil, nil", c, err)
+	}
+
+	sc = serveConfig{tlsCert: certFile, tlsKey: keyFile}
+	c, err = sc.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ClientAuth != tls.NoClientCert {
+		t.Errorf("ClientAuth = %v; want %v", c.ClientAuth, tls.NoClientCert)
+	}
+
+	sc.tlsClientCA = certFile
+	c, err = sc.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("ClientAuth = %v; want %v", c.ClientAuth, tls.RequireAndVerifyClientCert)
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key to files in a
+// temporary directory and returns their paths.
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tier test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestRunServerDrains(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan bool)
+	release := make(chan bool)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- runServer(ctx, srv, ln, 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		resc <- result{string(b), err}
+	}()
+
+	<-started
+	cancel()
+
+	// the in-flight request must finish before runServer returns
+	select {
+	case err := <-errc:
+		t.Fatalf("runServer returned before draining: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	if res := <-resc; res.err != nil || res.body != "done" {
+		t.Errorf("response = %q, %v; want %q, nil", res.body, res.err, "done")
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("runServer = %v; want nil", err)
+	}
+}
+
+func TestRunServerDrainTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- runServer(ctx, srv, ln, 10*time.Millisecond) }()
+	go http.Get("http://" + ln.Addr().String())
+
+	<-started
+	cancel()
+	if err := <-errc; err == nil {
+		t.Error("runServer = nil; want drain timeout error")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+// maxClockOrgs is the maximum number of customers Stripe allows under a test
+// clock.
+const maxClockOrgs = 3
+
+// A scenario is a script of steps played out against a test clock by
+// "tier simulate".
+type scenario struct {
+	Start time.Time      `json:"start"` // the clock's start time; defaults to now
+	Steps []scenarioStep `json:"steps"`
+}
+
+// A scenarioStep is a single step in a scenario. Exactly one field is set.
+type scenarioStep struct {
+	Subscribe *subscribeStep `json:"subscribe,omitempty"`
+	Report    *reportStep    `json:"report,omitempty"`
+	Advance   *advanceStep   `json:"advance,omitempty"`
+}
+
+type subscribeStep struct {
+	Org           string   `json:"org"`
+	Features      []string `json:"features"` // plans and feature plans
+	TrialDays     int      `json:"trial_days"`
+	PaymentMethod string   `json:"payment_method"`
+}
+
+type reportStep struct {
+	Org     string    `json:"org"`
+	Feature refs.Name `json:"feature"`
+	N       int       `json:"n"`
+	Clobber bool      `json:"clobber"`
+}
+
+type advanceStep struct {
+	Days  int        `json:"days"`
+	Hours int        `json:"hours"`
+	To    *time.Time `json:"to"` // overrides Days and Hours if set
+}
+
+// parseScenario decodes and checks the scenario in r. It returns the orgs in
+// the scenario in the order they first appear.
+func parseScenario(r io.Reader) (*scenario, []string, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var s scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, nil, fmt.Errorf("scenario: %w", err)
+	}
+	if len(s.Steps) == 0 {
+		return nil, nil, errors.New("scenario: no steps")
+	}
+
+	var orgs []string
+	addOrg := func(i int, org string) error {
+		if org == "" {
+			return fmt.Errorf("scenario: step %d: org required", i)
+		}
+		for _, o := range orgs {
+			if o == org {
+				return nil
+			}
+		}
+		orgs = append(orgs, org)
+		return nil
+	}
+
+	for i, st := range s.Steps {
+		n := 0
+		if st.Subscribe != nil {
+			n++
+			if err := addOrg(i, st.Subscribe.Org); err != nil {
+				return nil, nil, err
+			}
+		}
+		if st.Report != nil {
+			n++
+			if err := addOrg(i, st.Report.Org); err != nil {
+				return nil, nil, err
+			}
+			if st.Report.Feature == (refs.Name{}) {
+				return nil, nil, fmt.Errorf("scenario: step %d: feature required", i)
+			}
+		}
+		if st.Advance != nil {
+			n++
+			a := st.Advance
+			if a.To == nil && a.Days <= 0 && a.Hours <= 0 {
+				return nil, nil, fmt.Errorf("scenario: step %d: advance requires days, hours, or to", i)
+			}
+		}
+		if n != 1 {
+			return nil, nil, fmt.Errorf("scenario: step %d: must have exactly one of subscribe, report, or advance", i)
+		}
+	}
+	if len(orgs) > maxClockOrgs {
+		return nil, nil, fmt.Errorf("scenario: %d orgs found; Stripe allows at most %d per test clock", len(orgs), maxClockOrgs)
+	}
+	return &s, orgs, nil
+}
+
+// simulate plays out the scenario s using a new test clock named name, and
+// writes the resulting invoices for orgs to w.
+func simulate(ctx context.Context, c *control.Client, name string, s *scenario, orgs []string, w io.Writer) error {
+	if c.Stripe.Live() {
+		return errors.New("simulate is only available in test mode")
+	}
+
+	m, err := c.Pull(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	start := s.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	clock, err := c.NewClock(ctx, name, start)
+	if err != nil {
+		return err
+	}
+	vlogf("simulate: clock %s: %s", clock.ID(), clock.Link())
+	ctx = control.WithClock(ctx, clock.ID())
+
+	for i, st := range s.Steps {
+		if err := runStep(ctx, c, clock, m, st); err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+
+	invoices := map[string][]control.Invoice{}
+	for _, org := range orgs {
+		ins, err := c.LookupInvoices(ctx, org)
+		if err != nil {
+			return err
+		}
+		invoices[org] = ins
+	}
+	return printInvoices(w, orgs, invoices)
+}
+
+func runStep(ctx context.Context, c *control.Client, clock *control.Clock, m []control.Feature, st scenarioStep) error {
+	switch {
+	case st.Subscribe != nil:
+		sub := st.Subscribe
+		fs, err := control.Expand(m, sub.Features...)
+		if err != nil {
+			return err
+		}
+		if sub.PaymentMethod != "" {
+			if err := c.PutCustomer(ctx, sub.Org, &control.OrgInfo{
+				PaymentMethod: sub.PaymentMethod,
+				InvoiceSettings: control.InvoiceSettings{
+					DefaultPaymentMethod: sub.PaymentMethod,
+				},
+			}); err != nil {
+				return err
+			}
+		}
+		var ps []control.Phase
+		switch {
+		case sub.TrialDays < 0:
+			ps = []control.Phase{{Trial: true, Features: fs}}
+		case sub.TrialDays == 0:
+			ps = []control.Phase{{Features: fs}}
+		default:
+			ps = []control.Phase{{
+				Trial:    true,
+				Features: fs,
+			}, {
+				Effective: clock.Present().AddDate(0, 0, sub.TrialDays),
+				Features:  fs,
+			}}
+		}
+		return c.Schedule(ctx, sub.Org, control.ScheduleParams{Phases: ps})
+	case st.Report != nil:
+		rep := st.Report
+		return c.ReportUsage(ctx, rep.Org, rep.Feature, control.Report{
+			N:       rep.N,
+			At:      clock.Present(),
+			Clobber: rep.Clobber,
+		})
+	case st.Advance != nil:
+		a := st.Advance
+		to := clock.Present().AddDate(0, 0, a.Days).Add(time.Duration(a.Hours) * time.Hour)
+		if a.To != nil {
+			to = *a.To
+		}
+		if err := clock.Advance(ctx, to); err != nil {
+			return err
+		}
+		return clock.Wait(ctx)
+	}
+	return nil
+}
+
+func printInvoices(w io.Writer, orgs []string, invoices map[string][]control.Invoice) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORG\tPERIOD\tFEATURE\tQUANTITY\tAMOUNT")
+	for _, org := range orgs {
+		for _, in := range invoices[org] {
+			period := in.Period.Effective.UTC().Format("2006-01-02")
+			for _, l := range in.Lines {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n",
+					org,
+					period,
+					l.Feature,
+					l.Quantity,
+					control.FormatAmount(int(l.Amount), in.Currency),
+				)
+			}
+			fmt.Fprintf(tw, "%s\t%s\tTOTAL\t\t%s %s\n",
+				org,
+				period,
+				control.FormatAmount(in.Total, in.Currency),
+				in.Currency,
+			)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+func TestParseScenario(t *testing.T) {
+	s, orgs, err := parseScenario(strings.NewReader(`{
+		"start": "2026-01-01T00:00:00Z",
+		"steps": [
+			{"subscribe": {"org": "org:a", "features": ["plan:pro@2"], "trial_days": 14}},
+			{"report": {"org": "org:b", "feature": "feature:api", "n": 10}},
+			{"report": {"org": "org:a", "feature": "feature:api", "n": 5}},
+			{"advance": {"days": 31}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, orgs, []string{"org:a", "org:b"})
+	diff.Test(t, t.Errorf, s.Start, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	diff.Test(t, t.Errorf, len(s.Steps), 4)
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`{}`, "no steps"},
+		{`{"steps": [{}]}`, "step 0: must have exactly one"},
+		{`{"steps": [{"advance": {"days": 1}, "report": {"org": "org:a", "feature": "feature:x"}}]}`, "step 0: must have exactly one"},
+		{`{"steps": [{"advance": {}}]}`, "step 0: advance requires"},
+		{`{"steps": [{"advance": {"days": 1}}, {"report": {"feature": "feature:x"}}]}`, "step 1: org required"},
+		{`{"steps": [{"report": {"org": "org:a"}}]}`, "step 0: feature required"},
+		{`{"steps": [{"wait": {}}]}`, `unknown field "wait"`},
+		{`{"steps": [
+			{"subscribe": {"org": "org:a"}},
+			{"subscribe": {"org": "org:b"}},
+			{"subscribe": {"org": "org:c"}},
+			{"subscribe": {"org": "org:d"}}
+		]}`, "4 orgs found; Stripe allows at most 3"},
+	}
+	for _, tt := range cases {
+		_, _, err := parseScenario(strings.NewReader(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseScenario(%s) = %v, want error containing %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestPrintInvoices(t *testing.T) {
+	api := refs.MustParseFeaturePlan("feature:api@plan:pro@2")
+	day := func(d int) control.Period {
+		return control.Period{Effective: time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC)}
+	}
+
+	var b strings.Builder
+	err := printInvoices(&b, []string{"org:a", "org:b"}, map[string][]control.Invoice{
+		"org:a": {{
+			Currency: "usd",
+			Period:   day(1),
+			Lines:    []control.InvoiceLineItem{{Feature: api}},
+		}, {
+			Currency: "usd",
+			Period:   day(15),
+			Lines:    []control.InvoiceLineItem{{Feature: api, Quantity: 2000, Amount: 2000}},
+			Total:    2000,
+		}},
+		"org:b": {{
+			Currency: "jpy",
+			Period:   day(1),
+			Total:    500,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `ORG    PERIOD      FEATURE                 QUANTITY  AMOUNT
+org:a  2026-01-01  feature:api@plan:pro@2  0         0.00
+org:a  2026-01-01  TOTAL                             0.00 usd
+org:a  2026-01-15  feature:api@plan:pro@2  2000      20.00
+org:a  2026-01-15  TOTAL                             20.00 usd
+org:b  2026-01-01  TOTAL                             500 jpy
+`
+	diff.Test(t, t.Errorf, b.String(), want)
+}
+
+func TestSimulateLiveMode(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+	if err := os.WriteFile("scenario.json", []byte(`{"steps": [{"advance": {"days": 1}}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tt.Setenv("STRIPE_API_KEY", "sk_live_123")
+	tt.RunFail("--live", "simulate", "scenario.json")
+	tt.GrepStderr("only available in test mode", "expected test mode error")
+}
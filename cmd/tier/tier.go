@@ -28,6 +28,7 @@ import (
 	"tier.run/control"
 	"tier.run/mirror/x/exp/slices"
 	"tier.run/profile"
+	"tier.run/refs"
 	"tier.run/stripe"
 	"tier.run/version"
 )
@@ -37,6 +38,8 @@ var (
 	flagLive     = flag.Bool("live", false, "use live Stripe key (default is false)")
 	flagVerbose  = flag.Bool("v", false, "verbose output")
 	flagMainHelp = flag.Bool("h", false, "show this message")
+	flagClock    = flag.String("clock", "", "run commands against the test clock with the provided ID")
+	flagProfile  = flag.String("profile", "", "use the named profile (default is $TIER_PROFILE, or the profile selected with 'tier profiles use')")
 )
 
 // Env
@@ -165,10 +168,11 @@ func runTier(cmd string, args []string) (err error) {
 		fmt.Println(version.String())
 		return nil
 	case "init":
-		panic("TODO")
+		return initProject(ctx, p, args)
 	case "push":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 		create := fs.Bool("c", false, "create a new isolated account and push to it")
+		format := fs.String("format", "", "pricing format: json, yaml, or toml (default is by file extension, or json)")
 		if err := fs.Parse(args); err != nil {
 			return err
 		}
@@ -189,54 +193,56 @@ func runTier(cmd string, args []string) (err error) {
 			pj = fs.Arg(0)
 		}
 
-		f, _, err := stdinRemoteOrFile(ctx, pj)
-		if err != nil {
-			return err
+		pf := pricingFormat(pj)
+		if *format != "" {
+			pf, err = materialize.ParseFormat(*format)
+			if err != nil {
+				return err
+			}
 		}
-		defer f.Close()
 
-		err = pushJSON(ctx, f, func(f control.Feature, err error) {
-			aid := cc().Stripe.AccountID
-			if aid == "" && envAPIKey == "" {
-				aid = p.AccountID
-			}
-			link, uerr := stripe.Link(cc().Live(), aid, "prices", f.ProviderID)
-			if uerr != nil {
-				panic(uerr)
-			}
-			var status, reason string
-			switch err {
-			case nil:
-				status = "ok"
-				reason = "created"
-			case control.ErrFeatureExists:
-				status = "ok"
-				reason = "feature already exists"
-			default:
-				status = "failed"
-				reason = err.Error()
-				link = "-"
+		return pushFile(ctx, p, pj, pf)
+	case "validate":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		format := fs.String("format", "", "pricing format: json, yaml, or toml (default is by file extension, or json)")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		name := fs.Arg(0)
+		pf := pricingFormat(name)
+		if *format != "" {
+			pf, err = materialize.ParseFormat(*format)
+			if err != nil {
+				return err
 			}
-
-			fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\t[%s]\n",
-				status,
-				f.Plan(),
-				f.Name(),
-				link,
-				reason,
-			)
-		})
-		if errors.Is(err, control.ErrPlanExists) {
-			//lint:ignore ST1005 this error is not used like normal errors
-			return fmt.Errorf("illegal attempt to push features to existing plan(s); aborting.")
 		}
-		return err
+		return validatePricing(ctx, name, pf)
 	case "pull":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		format := fs.String("format", "json", "output format: json, yaml, or toml")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		pf, err := materialize.ParseFormat(*format)
+		if err != nil {
+			return err
+		}
 		data, err := tc().PullJSON(ctx)
 		if err != nil {
 			return err
 		}
-		fmt.Fprintf(stdout, "%s\n", data)
+		data, err = materialize.ConvertPricingJSON(data, pf)
+		if err != nil {
+			return err
+		}
+		if pf == materialize.FormatJSON {
+			fmt.Fprintf(stdout, "%s\n", data)
+		} else {
+			stdout.Write(data)
+		}
 		return nil
 	case "ls":
 		m, err := tc().Pull(ctx)
@@ -269,7 +275,17 @@ func runTier(cmd string, args []string) (err error) {
 
 		return nil
 	case "connect":
-		return connect()
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		name := fs.String("profile", profileName(), "the name of the profile to save the keys under")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 0 {
+			return errUsage
+		}
+		return connect(*name)
+	case "profiles":
+		return runProfiles(args)
 	case "subscribe":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 		email := fs.String("email", "", "sets the customer email address")
@@ -399,6 +415,96 @@ func runTier(cmd string, args []string) (err error) {
 			)
 		}
 		return nil
+	case "quote":
+		qr, err := parseQuoteArgs(args)
+		if err != nil {
+			return err
+		}
+		q, err := tc().Quote(ctx, qr)
+		if err != nil {
+			return err
+		}
+		tw := newTabWriter()
+		defer tw.Flush()
+		fmt.Fprintln(tw, "FEATURE\tQUANTITY\tBILLED\tAMOUNT")
+		for _, l := range q.Lines {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n",
+				l.Feature,
+				l.Quantity,
+				l.Billed,
+				control.FormatAmount(l.Amount, q.Currency),
+			)
+		}
+		fmt.Fprintf(tw, "TOTAL\t\t\t%s %s\n", control.FormatAmount(q.Total, q.Currency), q.Currency)
+		return nil
+	case "simulate":
+		if len(args) != 1 {
+			return errUsage
+		}
+		r, _, err := stdinRemoteOrFile(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		s, orgs, err := parseScenario(r)
+		if err != nil {
+			return err
+		}
+		return simulate(ctx, cc(), "tier simulate "+args[0], s, orgs, stdout)
+	case "revenue":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		format := fs.String("format", "table", "output format: table, csv, or json")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 0 {
+			return errUsage
+		}
+		switch *format {
+		case "table", "csv", "json":
+		default:
+			return fmt.Errorf("unknown format %q; must be table, csv, or json", *format)
+		}
+		rr, err := tc().Revenue(ctx)
+		if err != nil {
+			return err
+		}
+		return printRevenue(stdout, *format, rr)
+	case "audit":
+		return runAudit(ctx, args)
+	case "export":
+		if len(args) != 0 {
+			return errUsage
+		}
+		b, err := exportBackup(ctx, cc())
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(b, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+		return nil
+	case "import":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "report what would be done without making changes")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		r, _, err := stdinRemoteOrFile(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		b, err := readBackup(r)
+		if err != nil {
+			return err
+		}
+		return importBackup(ctx, cc(), b, *dryRun, stdout)
 	case "report":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 		clobber := fs.Bool("clobber", false, "clobber existing value")
@@ -439,6 +545,9 @@ func runTier(cmd string, args []string) (err error) {
 		defer tw.Flush()
 		fmt.Fprintf(tw, "ID:\t%v\n", who.ProviderID)
 		fmt.Fprintf(tw, "KeySource:\t%v\n", who.KeySource)
+		if envAPIKey == "" {
+			fmt.Fprintf(tw, "Profile:\t%v\n", profileName())
+		}
 		fmt.Fprintf(tw, "Isolated:\t%v\n", who.Isolated)
 		fmt.Fprintf(tw, "Email:\t%v\n", who.Email)
 		fmt.Fprintf(tw, "Created:\t%v\n", who.Created.Format(time.RFC3339))
@@ -460,13 +569,54 @@ func runTier(cmd string, args []string) (err error) {
 		return nil
 	case "serve":
 		fs := flag.NewFlagSet("serve", flag.ExitOnError)
-		addr := fs.String("addr", ":8080", "address to listen on (default ':8080')")
+		addr := fs.String("addr", ":8080", "address to listen on (default ':8080'); use unix:///path for a Unix domain socket")
+		withMetrics := fs.Bool("metrics", false, "serve Prometheus metrics at /metrics")
+		insecure := fs.Bool("insecure", false, "serve without requiring tokens")
+		tlsCert := fs.String("tls-cert", "", "serve HTTPS using the PEM encoded certificate file")
+		tlsKey := fs.String("tls-key", "", "serve HTTPS using the PEM encoded private key file")
+		tlsClientCA := fs.String("tls-client-ca", "", "require client certificates signed by the CAs in the PEM encoded file")
+		accounts := fs.String("accounts", "", "comma separated list of Stripe connected accounts requests may use via the Tier-Account header")
+		drainTimeout := fs.Duration("drain-timeout", 30*time.Second, "how long to wait for in-flight requests to finish on shutdown")
+		cacheSize := fs.Int("cache-size", control.DefaultCacheSize, "the number of org to customer IDs to cache in memory")
+		cacheTTL := fs.Duration("cache-ttl", 0, "how long to cache customer IDs; 0 means until evicted")
+		cacheNegativeTTL := fs.Duration("cache-negative-ttl", 0, "how long to remember orgs without a customer; 0 means not at all")
+		cacheDir := fs.String("cache-dir", "", "a directory to persist customer IDs in across restarts")
+		auditLog := fs.String("audit-log", "", "append mutating requests to the file as JSON lines")
+		modelTTL := fs.Duration("model-ttl", 5*time.Minute, "how long to cache the pricing model; 0 means not at all")
 		if err := fs.Parse(args); err != nil {
 			return err
 		}
-		return serve(*addr)
+		cache := control.CacheConfig{
+			Size:        *cacheSize,
+			TTL:         *cacheTTL,
+			NegativeTTL: *cacheNegativeTTL,
+		}
+		if *cacheDir != "" {
+			if *cacheTTL <= 0 {
+				return errors.New("--cache-dir requires a --cache-ttl greater than 0")
+			}
+			cache.Store = &control.FileStore{Dir: *cacheDir, TTL: *cacheTTL, Logf: vlogf}
+		}
+		return serve(serveConfig{
+			addr:        *addr,
+			metrics:     *withMetrics,
+			insecure:    *insecure,
+			accounts:    splitList(*accounts),
+			tlsCert:     *tlsCert,
+			tlsKey:      *tlsKey,
+			tlsClientCA: *tlsClientCA,
+
+			drainTimeout: *drainTimeout,
+			cache:        cache,
+			modelTTL:     *modelTTL,
+			auditLog:     *auditLog,
+		})
 	case "switch":
 		return switchAccounts(ctx, args...)
+	case "clock":
+		return runClock(ctx, args)
+	case "doctor":
+		return runDoctor(ctx, args)
 	case "clean":
 		fs := flag.NewFlagSet("clean", flag.ExitOnError)
 		accountAge := fs.Duration("switchaccounts", -1, "garbage collect switch accounts older than a duration; default is -1")
@@ -579,18 +729,139 @@ func newID() string {
 	return hex.EncodeToString(buf[:])
 }
 
-func pushJSON(ctx context.Context, r io.Reader, cb func(control.Feature, error)) error {
+// pricingFormat returns the format of the pricing file, or URL, name.
+func pricingFormat(name string) materialize.Format {
+	if u, err := url.Parse(name); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		name = u.Path
+	}
+	return materialize.FormatFromName(name)
+}
+
+// validatePricing reports the problems in the pricing model read from name,
+// one per line, in the form "name:line:column: path: message".
+func validatePricing(ctx context.Context, name string, format materialize.Format) error {
+	f, _, err := stdinRemoteOrFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	return reportProblems(name, materialize.Validate(data, format))
+}
+
+// reportProblems writes each problem in err to stdout if it is
+// materialize.Problems, and returns an error with their count; otherwise it
+// returns err.
+func reportProblems(name string, err error) error {
+	var ps materialize.Problems
+	if !errors.As(err, &ps) {
+		return err
+	}
+	for _, p := range ps {
+		sep := ":"
+		if p.Line == 0 {
+			sep = ": "
+		}
+		fmt.Fprintf(stdout, "%s%s%v\n", name, sep, p)
+	}
+	if len(ps) == 1 {
+		return errors.New("1 problem found")
+	}
+	return fmt.Errorf("%d problems found", len(ps))
+}
+
+// pushFile pushes the pricing model in the file, URL, or stdin named by name
+// and reports the outcome for each feature.
+func pushFile(ctx context.Context, p *profile.Profile, name string, pf materialize.Format) error {
+	f, _, err := stdinRemoteOrFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = pushPricing(ctx, f, pf, func(f control.Feature, err error) {
+		aid := cc().Stripe.AccountID
+		if aid == "" && envAPIKey == "" {
+			aid = p.AccountID
+		}
+		link, uerr := stripe.Link(cc().Live(), aid, "prices", f.ProviderID)
+		if uerr != nil {
+			panic(uerr)
+		}
+		var status, reason string
+		switch err {
+		case nil:
+			status = "ok"
+			reason = "created"
+		case control.ErrFeatureExists:
+			status = "ok"
+			reason = "feature already exists"
+		default:
+			status = "failed"
+			reason = err.Error()
+			link = "-"
+		}
+
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\t[%s]\n",
+			status,
+			f.Plan(),
+			f.Name(),
+			link,
+			reason,
+		)
+	})
+	if errors.Is(err, control.ErrPlanExists) {
+		//lint:ignore ST1005 this error is not used like normal errors
+		return fmt.Errorf("illegal attempt to push features to existing plan(s); aborting.")
+	}
+	return reportProblems(name, err)
+}
+
+func pushPricing(ctx context.Context, r io.Reader, format materialize.Format, cb func(control.Feature, error)) error {
 	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	fs, err := materialize.FromPricingHuJSON(data)
+	fs, err := materialize.FromPricing(data, format)
 	if err != nil {
 		return err
 	}
 	return cc().Push(ctx, fs, cb)
 }
 
+// parseQuoteArgs parses the arguments to quote: plans and feature plans,
+// and usage in the form feature:name=n.
+func parseQuoteArgs(args []string) (apitypes.QuoteRequest, error) {
+	var qr apitypes.QuoteRequest
+	for _, arg := range args {
+		name, sn, ok := strings.Cut(arg, "=")
+		if !ok {
+			qr.Features = append(qr.Features, arg)
+			continue
+		}
+		fn, err := refs.ParseName(name)
+		if err != nil {
+			return qr, err
+		}
+		n, err := strconv.Atoi(sn)
+		if err != nil {
+			return qr, fmt.Errorf("invalid usage for %s: %q", fn, sn)
+		}
+		if qr.Usage == nil {
+			qr.Usage = map[refs.Name]int{}
+		}
+		qr.Usage[fn] = n
+	}
+	if len(qr.Features) == 0 {
+		return qr, errUsage
+	}
+	return qr, nil
+}
+
 func newTabWriter() *tabwriter.Writer {
 	return tabwriter.NewWriter(stdout, 0, 2, 2, ' ', 0)
 }
@@ -610,7 +881,7 @@ func tc() *tier.Client {
 		// TODO(bmizerany): hookup logging, timeouts, etc
 		tierClient = &tier.Client{
 			HTTPClient: &http.Client{
-				Transport: &clientTransport{h},
+				Transport: &clientTransport{h: h, clock: *flagClock},
 			},
 		}
 	}
@@ -618,7 +889,8 @@ func tc() *tier.Client {
 }
 
 type clientTransport struct {
-	h http.Handler
+	h     http.Handler
+	clock string // the -clock flag; sent as the Tier-Clock header if set
 }
 
 func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -628,13 +900,17 @@ func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// on a port. If I did spawn a server, it would add extra latency to
 	// the cli which could easily be avoided. Still, it feels like a hack,
 	// but it works great.
+	if t.clock != "" && req.Header.Get(tier.ClockHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(tier.ClockHeader, t.clock)
+	}
 	w := httptest.NewRecorder()
 	t.h.ServeHTTP(w, req)
 	return w.Result(), nil
 }
 
 func loadProfile() *profile.Profile {
-	p, err := profile.Load("tier")
+	p, err := profile.Load(profileName())
 	if err != nil {
 		vlogf("tier: %v", err)
 		p = &profile.Profile{
@@ -372,6 +372,7 @@ func TestWhoAmI(t *testing.T) {
 	tt.GrepStdout(`Created:\s+\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}`, "expected created")
 	tt.GrepStdout(`https://dashboard.stripe.com/acct_.*`, "expected URL")
 	tt.GrepStdout(`KeySource:.*config.json`, "expected accountID")
+	tt.GrepStdout(`Profile:\s+tier`, "expected profile")
 
 	// undo the "switch"
 	if err := os.Remove("tier.state"); err != nil {
@@ -382,6 +383,7 @@ func TestWhoAmI(t *testing.T) {
 	tt.Run("whoami")
 	tt.GrepStdout(`Isolated:\s+false`, "expected accountID")
 	tt.GrepStdout(`KeySource:\s+STRIPE_API_KEY`, "expected accountID")
+	tt.GrepBothNot(`Profile:`, "unexpected profile with STRIPE_API_KEY")
 }
 
 func TestIsolatedAccountInvalid(t *testing.T) {
@@ -629,3 +631,64 @@ func (s *atomicSlice[T]) Load() []T {
 	s.mu.Unlock()
 	return v
 }
+
+func TestValidate(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("good.yaml", "plans:\n  plan:free@0:\n    features:\n      feature:x: {}\n")
+	write("bad.json", `{
+	"plans": {
+		"plan:free@0": {
+			"features": {"feature:x": {"divide": {"by": 0}}}
+		}
+	}
+}`)
+
+	tt.Run("validate", "good.yaml")
+
+	tt.RunFail("validate", "bad.json")
+	tt.GrepStdout(`^bad.json:4:48: plans\["plan:free@0"\].features\["feature:x"\].divide.by: by must be greater than zero$`, "expected problem with position")
+	tt.GrepStderr("1 problem found", "expected problem count")
+
+	tt.RunFail("validate", "--format", "yaml", "bad.json")
+	tt.GrepStdout(`^bad.json:`, "expected problem")
+}
+
+func TestQuote(t *testing.T) {
+	tt := testtier(t, func(w http.ResponseWriter, r *http.Request) {
+		if !they.Want(r, "GET", "/v1/prices") {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			return
+		}
+		io.WriteString(w, `{"data": [{
+			"metadata": {"tier.feature": "feature:api@plan:pro@2"},
+			"currency": "jpy",
+			"recurring": {"interval": "month", "usage_type": "metered", "aggregate_usage": "sum"},
+			"billing_scheme": "per_unit",
+			"unit_amount_decimal": "0.01"
+		}, {
+			"metadata": {"tier.feature": "feature:base@plan:pro@2"},
+			"currency": "jpy",
+			"recurring": {"interval": "month", "usage_type": "licensed"},
+			"billing_scheme": "per_unit",
+			"unit_amount_decimal": "5000"
+		}]}`)
+	})
+
+	tt.Run("quote", "plan:pro@2", "feature:api=1200000")
+	tt.GrepStdout(`^feature:api@plan:pro@2\s+1200000\s+1200000\s+12000$`, "expected api line")
+	tt.GrepStdout(`^feature:base@plan:pro@2\s+1\s+1\s+5000$`, "expected base line")
+	tt.GrepStdout(`^TOTAL\s+17000 jpy$`, "expected total")
+
+	tt.RunFail("quote", "plan:pro@2", "feature:api=lots")
+	tt.GrepStderr(`invalid usage for feature:api: "lots"`, "expected usage error")
+
+	tt.RunFail("quote")
+	tt.GrepStderr("Usage:", "expected usage")
+}
@@ -1,39 +1,132 @@
 package control
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/groupcache/singleflight"
 	"tier.run/lru"
+	"tier.run/metrics"
+	"tier.run/stripe"
 )
 
+var (
+	metricCacheHits = metrics.NewCounterVec("tier_org_cache_hits_total",
+		"Org to customer ID cache hits.")
+	metricCacheMisses = metrics.NewCounterVec("tier_org_cache_misses_total",
+		"Org to customer ID cache misses.")
+	metricCacheEvictions = metrics.NewCounterVec("tier_org_cache_evictions_total",
+		"Org to customer ID cache evictions.")
+)
+
+// DefaultCacheSize is the number of orgs cached when CacheConfig.Size is
+// zero.
+const DefaultCacheSize = 100
+
+// CacheConfig configures the cache of org to Stripe customer IDs used to
+// avoid searching all customers for an org on each request.
+type CacheConfig struct {
+	// Size is the maximum number of orgs kept in memory. If zero,
+	// DefaultCacheSize is used.
+	Size int
+
+	// TTL is how long a customer ID is cached. If zero, customer IDs are
+	// cached until evicted.
+	TTL time.Duration
+
+	// NegativeTTL is how long an org without a customer is remembered as
+	// not found. If zero, orgs not found are not cached.
+	NegativeTTL time.Duration
+
+	// Store, if not nil, persists customer IDs so that they outlive the
+	// process. It is consulted on each miss in memory.
+	Store OrgStore
+}
+
+// An OrgStore persists the customer IDs for orgs. Implementations must be
+// safe for concurrent use.
+type OrgStore interface {
+	// Get returns the customer ID for org in the account and test clock,
+	// and when it was stored.
+	Get(account, clock, org string) (customerID string, stored time.Time, ok bool)
+
+	// Put records the customer ID for org in the account and test clock.
+	Put(account, clock, org, customerID string)
+}
+
 type orgKey struct {
 	account string
 	clock   string // a test clock, if any
 	name    string
 }
 
+// A memoEntry is a cached customer ID, or a record that no customer was
+// found.
+type memoEntry struct {
+	customerID string
+	notFound   bool
+	expires    time.Time // zero means never
+}
+
+func (e memoEntry) result() (string, error) {
+	if e.notFound {
+		return "", stripe.ErrNotFound
+	}
+	return e.customerID, nil
+}
+
 type memo struct {
 	m     sync.Mutex
-	lru   *lru.Cache[orgKey, string] // map[orgKey] -> customerID
+	lru   *lru.Cache[orgKey, memoEntry] // map[orgKey] -> customerID
 	group singleflight.Group
 }
 
-func (m *memo) lookupCache(key orgKey) (string, bool) {
+func (m *memo) lookupCache(key orgKey, negative bool) (memoEntry, bool) {
 	m.m.Lock()
 	defer m.m.Unlock()
 	if m.lru == nil {
-		return "", false
+		return memoEntry{}, false
+	}
+	e, ok := m.lru.Get(key)
+	if !ok {
+		return memoEntry{}, false
+	}
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		m.lru.Remove(key)
+		return memoEntry{}, false
 	}
-	return m.lru.Get(key)
+	if e.notFound && !negative {
+		return memoEntry{}, false
+	}
+	return e, true
+}
+
+// load returns the customer ID for key from the cache, or calls fn and
+// caches the result if it is not cached.
+func (m *memo) load(cfg CacheConfig, key orgKey, fn func() (string, error)) (string, error) {
+	return m.do(cfg, key, false, fn)
+}
+
+// lookup is like load, but if cfg.NegativeTTL is set, also caches fn
+// reporting stripe.ErrNotFound, and returns stripe.ErrNotFound for orgs
+// cached as not found.
+func (m *memo) lookup(cfg CacheConfig, key orgKey, fn func() (string, error)) (string, error) {
+	return m.do(cfg, key, cfg.NegativeTTL > 0, fn)
 }
 
-func (m *memo) load(key orgKey, fn func() (string, error)) (string, error) {
-	s, cacheHit := m.lookupCache(key)
-	if cacheHit {
-		return s, nil
+func (m *memo) do(cfg CacheConfig, key orgKey, negative bool, fn func() (string, error)) (string, error) {
+	if e, ok := m.cached(cfg, key, negative); ok {
+		metricCacheHits.Inc()
+		return e.result()
 	}
+	metricCacheMisses.Inc()
 
 	// TODO(bmizerany): make a singleflight with generics to avoid building
 	// a string instead of using orgKey as a key
@@ -43,29 +136,251 @@ func (m *memo) load(key orgKey, fn func() (string, error)) (string, error) {
 	b.WriteString(key.name)
 
 	v, err := m.group.Do(b.String(), func() (any, error) {
-		v, cacheHit := m.lookupCache(key)
-		if cacheHit {
-			return v, nil
+		if e, ok := m.cached(cfg, key, negative); ok {
+			return e, nil
 		}
 		s, err := fn()
+		if negative && errors.Is(err, stripe.ErrNotFound) {
+			e := memoEntry{notFound: true, expires: time.Now().Add(cfg.NegativeTTL)}
+			m.add(cfg, key, e)
+			return e, nil
+		}
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+		e := memoEntry{customerID: s, expires: expiresAfter(time.Now(), cfg.TTL)}
+		m.add(cfg, key, e)
+		if cfg.Store != nil && s != "" {
+			cfg.Store.Put(key.account, key.clock, key.name, s)
 		}
-		m.add(key, s)
-		return s, nil
+		return e, nil
 	})
 	if err != nil {
 		return "", err
 	}
+	return v.(memoEntry).result()
+}
 
-	return v.(string), nil
+// cached returns the entry for key from memory, or from cfg.Store if set.
+func (m *memo) cached(cfg CacheConfig, key orgKey, negative bool) (memoEntry, bool) {
+	if e, ok := m.lookupCache(key, negative); ok {
+		return e, true
+	}
+	if cfg.Store == nil {
+		return memoEntry{}, false
+	}
+	s, stored, ok := cfg.Store.Get(key.account, key.clock, key.name)
+	if !ok || s == "" {
+		return memoEntry{}, false
+	}
+	e := memoEntry{customerID: s, expires: expiresAfter(stored, cfg.TTL)}
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		return memoEntry{}, false
+	}
+	m.add(cfg, key, e)
+	return e, true
 }
 
-func (m *memo) add(key orgKey, val string) {
+func (m *memo) add(cfg CacheConfig, key orgKey, e memoEntry) {
 	m.m.Lock()
 	defer m.m.Unlock()
 	if m.lru == nil {
-		m.lru = lru.New[orgKey, string](100) // TODO(bmizerany): make configurable
+		size := cfg.Size
+		if size <= 0 {
+			size = DefaultCacheSize
+		}
+		m.lru = lru.New[orgKey, memoEntry](size)
+		m.lru.OnEvicted = func(orgKey, memoEntry) { metricCacheEvictions.Inc() }
+	}
+	m.lru.Add(key, e)
+}
+
+func expiresAfter(t time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return t.Add(ttl)
+}
+
+// A FileStore is an OrgStore that keeps customer IDs in files in a
+// directory, one per account and test clock. Each file is a log of lines
+// holding an org, its customer ID, and the unix time it was stored, and is
+// read in full the first time the account and clock are used. Logs are
+// compacted when read, and as they grow, to hold only the latest entry for
+// each org that has not expired.
+type FileStore struct {
+	Dir  string
+	Logf func(format string, args ...any) // optional
+
+	// TTL is how long entries are kept. Older entries are dropped when a
+	// log is compacted. If zero, entries are kept until replaced.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	files map[string]*orgLog // by file name
+}
+
+type orgLog struct {
+	orgs  map[string]storedOrg
+	lines int // lines in the file, including replaced entries
+}
+
+type storedOrg struct {
+	customerID string
+	stored     time.Time
+}
+
+// minCompactLines is the fewest lines in a log before it is compacted as it
+// grows.
+const minCompactLines = 64
+
+// fileName returns the name of the file for the account and clock.
+func (s *FileStore) fileName(account, clock string) string {
+	name := "orgs"
+	if account != "" {
+		name += "-" + account
+	}
+	if clock != "" {
+		name += "-" + clock
+	}
+	return filepath.Join(s.Dir, name+".log")
+}
+
+func (s *FileStore) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// log returns the log in name, reading and compacting the file if needed.
+// It must be called with s.mu held.
+func (s *FileStore) log(name string) *orgLog {
+	if l, ok := s.files[name]; ok {
+		return l
+	}
+	l, err := readOrgLog(name)
+	if err != nil {
+		s.logf("tier: org store: %v", err)
+	}
+	if s.files == nil {
+		s.files = map[string]*orgLog{}
+	}
+	s.files[name] = l
+	if err == nil {
+		s.compact(name, l)
+	}
+	return l
+}
+
+// compact drops expired entries from l, and rewrites its file if it holds
+// any lines that are not needed. It must be called with s.mu held.
+func (s *FileStore) compact(name string, l *orgLog) {
+	if s.TTL > 0 {
+		for org, o := range l.orgs {
+			if time.Since(o.stored) >= s.TTL {
+				delete(l.orgs, org)
+			}
+		}
+	}
+	if l.lines == len(l.orgs) {
+		return
+	}
+	if err := writeOrgLog(name, l.orgs); err != nil {
+		s.logf("tier: org store: %v", err)
+		return
+	}
+	l.lines = len(l.orgs)
+}
+
+func readOrgLog(name string) (*orgLog, error) {
+	l := &orgLog{orgs: map[string]storedOrg{}}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		l.lines++
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 {
+			continue // skip partially written lines
+		}
+		sec, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		l.orgs[fields[0]] = storedOrg{fields[1], time.Unix(sec, 0)}
+	}
+	return l, sc.Err()
+}
+
+// writeOrgLog replaces the log in name with one holding orgs. The file is
+// replaced atomically, so that it is never left partially written.
+func writeOrgLog(name string, orgs map[string]storedOrg) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".orgs-*.log") // created with mode 0600
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails once renamed
+	w := bufio.NewWriter(f)
+	for org, o := range orgs {
+		fmt.Fprintf(w, "%s %s %d\n", org, o.customerID, o.stored.Unix())
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Get implements OrgStore.
+func (s *FileStore) Get(account, clock, org string) (string, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.log(s.fileName(account, clock)).orgs[org]
+	return o.customerID, o.stored, ok
+}
+
+// Put implements OrgStore. Errors writing the file are logged and
+// otherwise ignored, since the store is only a cache.
+func (s *FileStore) Put(account, clock, org, customerID string) {
+	if strings.ContainsAny(org, " \t\r\n") {
+		return // would corrupt the log; orgs this odd just aren't stored
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.fileName(account, clock)
+	l := s.log(name)
+	now := time.Now()
+	l.orgs[org] = storedOrg{customerID, now}
+	if err := appendOrgLog(name, org, customerID, now); err != nil {
+		s.logf("tier: org store: %v", err)
+		return
+	}
+	l.lines++
+	if l.lines >= minCompactLines && l.lines >= 2*len(l.orgs) {
+		s.compact(name, l)
+	}
+}
+
+func appendOrgLog(name, org, customerID string, t time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s %d\n", org, customerID, t.Unix()); err != nil {
+		f.Close()
+		return err
 	}
-	m.lru.Add(key, val)
+	return f.Close()
 }
@@ -0,0 +1,236 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/stripe"
+)
+
+func TestMemoSize(t *testing.T) {
+	var m memo
+	cfg := CacheConfig{Size: 2}
+	calls := 0
+	load := func(name string) {
+		t.Helper()
+		_, err := m.load(cfg, orgKey{name: name}, func() (string, error) {
+			calls++
+			return "cus_" + name, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	load("org:a")
+	load("org:b")
+	load("org:a")
+	load("org:c") // evicts org:b
+	load("org:b")
+	diff.Test(t, t.Errorf, calls, 4)
+}
+
+func TestMemoTTL(t *testing.T) {
+	var m memo
+	cfg := CacheConfig{TTL: 50 * time.Millisecond}
+	calls := 0
+	fn := func() (string, error) {
+		calls++
+		return "cus_a", nil
+	}
+	key := orgKey{name: "org:a"}
+	for i := 0; i < 2; i++ {
+		if _, err := m.load(cfg, key, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff.Test(t, t.Errorf, calls, 1)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := m.load(cfg, key, fn); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, calls, 2)
+}
+
+func TestMemoNegative(t *testing.T) {
+	var m memo
+	key := orgKey{name: "org:a"}
+	calls := 0
+	notFound := func() (string, error) {
+		calls++
+		return "", stripe.ErrNotFound
+	}
+
+	// not cached without NegativeTTL
+	for i := 0; i < 2; i++ {
+		_, err := m.lookup(CacheConfig{}, key, notFound)
+		if !errors.Is(err, stripe.ErrNotFound) {
+			t.Fatalf("err = %v, want ErrNotFound", err)
+		}
+	}
+	diff.Test(t, t.Errorf, calls, 2)
+
+	cfg := CacheConfig{NegativeTTL: 50 * time.Millisecond}
+	calls = 0
+	for i := 0; i < 2; i++ {
+		_, err := m.lookup(cfg, key, notFound)
+		if !errors.Is(err, stripe.ErrNotFound) {
+			t.Fatalf("err = %v, want ErrNotFound", err)
+		}
+	}
+	diff.Test(t, t.Errorf, calls, 1)
+
+	// load, as used when creating customers, ignores negative entries
+	got, err := m.load(cfg, key, func() (string, error) { return "cus_a", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, "cus_a")
+	got, err = m.lookup(cfg, key, notFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, "cus_a")
+
+	// negative entries expire
+	calls = 0
+	key = orgKey{name: "org:b"}
+	m.lookup(cfg, key, notFound)
+	time.Sleep(60 * time.Millisecond)
+	m.lookup(cfg, key, notFound)
+	diff.Test(t, t.Errorf, calls, 2)
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileStore{Dir: dir}
+	s.Put("acct_1", "", "org:a", "cus_a")
+	s.Put("acct_1", "clock_1", "org:a", "cus_clock")
+	s.Put("", "", "org:a", "cus_root")
+	s.Put("acct_1", "", "org:a", "cus_a2")
+
+	// a new store, as after a restart, reads the files
+	s = &FileStore{Dir: dir}
+	check := func(account, clock, want string) {
+		t.Helper()
+		got, stored, ok := s.Get(account, clock, "org:a")
+		if !ok || got != want {
+			t.Errorf("Get(%q, %q) = %q, %v; want %q", account, clock, got, ok, want)
+		}
+		if time.Since(stored) > time.Minute {
+			t.Errorf("stored = %v; want recent", stored)
+		}
+	}
+	check("acct_1", "", "cus_a2")
+	check("acct_1", "clock_1", "cus_clock")
+	check("", "", "cus_root")
+	if _, _, ok := s.Get("acct_2", "", "org:a"); ok {
+		t.Error("unexpected org in other account")
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, len(names), 3)
+
+	// partial lines are skipped
+	name := filepath.Join(dir, "orgs-acct_1.log")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("org:b cus_")
+	f.Close()
+	s = &FileStore{Dir: dir}
+	if _, _, ok := s.Get("acct_1", "", "org:b"); ok {
+		t.Error("unexpected org from partial line")
+	}
+
+	// reading the log compacted it to the latest entry
+	data, _ := os.ReadFile(name)
+	if !strings.HasPrefix(string(data), "org:a cus_a2 ") || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("unexpected log: %q", data)
+	}
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "orgs-acct_1.log")
+	old := time.Now().Add(-2 * time.Hour).Unix()
+	log := fmt.Sprintf("org:a cus_old %d\norg:b cus_b %d\norg:b cus_b2 %d\n", old, old+7200, old+7200)
+	if err := os.WriteFile(name, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// expired and replaced entries are dropped when the log is read
+	s := &FileStore{Dir: dir, TTL: time.Hour}
+	if _, _, ok := s.Get("acct_1", "", "org:a"); ok {
+		t.Error("unexpected expired org")
+	}
+	got, _, _ := s.Get("acct_1", "", "org:b")
+	diff.Test(t, t.Errorf, got, "cus_b2")
+	lines := func() int {
+		t.Helper()
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+	diff.Test(t, t.Errorf, lines(), 1)
+
+	// and as the log grows
+	for i := 0; i < 3*minCompactLines; i++ {
+		s.Put("acct_1", "", "org:c", fmt.Sprintf("cus_c%d", i))
+	}
+	if n := lines(); n >= minCompactLines {
+		t.Errorf("log has %d lines; want fewer than %d", n, minCompactLines)
+	}
+	s = &FileStore{Dir: dir, TTL: time.Hour}
+	got, _, _ = s.Get("acct_1", "", "org:c")
+	diff.Test(t, t.Errorf, got, fmt.Sprintf("cus_c%d", 3*minCompactLines-1))
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, names, []string{name})
+}
+
+func TestMemoStore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := CacheConfig{Store: &FileStore{Dir: dir}, TTL: time.Hour}
+	key := orgKey{account: "acct_1", name: "org:a"}
+
+	var m memo
+	if _, err := m.load(cfg, key, func() (string, error) { return "cus_a", nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new memo and store, as after a restart, do not call fn
+	cfg.Store = &FileStore{Dir: dir}
+	var m2 memo
+	got, err := m2.lookup(cfg, key, func() (string, error) {
+		t.Error("unexpected call")
+		return "", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, "cus_a")
+
+	// stored entries older than the TTL are ignored
+	cfg.TTL = time.Nanosecond
+	var m3 memo
+	got, err = m3.lookup(cfg, key, func() (string, error) { return "cus_new", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, "cus_new")
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/golang/groupcache/singleflight"
 	"golang.org/x/sync/errgroup"
@@ -142,8 +143,17 @@ type Client struct {
 	Logf      func(format string, args ...any)
 	Stripe    *stripe.Client
 	KeySource string // the source of the API key
+	Cache     CacheConfig
 
-	cache memo
+	cache        memo
+	consumeLocks keyedMutex
+
+	pendingMu sync.Mutex
+	pending   map[consumeKey]pendingUsage // guarded by pendingMu
+
+	// searchUnavailable is set if Stripe rejects customer searches, such
+	// as in regions where search is not supported.
+	searchUnavailable atomic.Bool
 }
 
 // Live reports if APIKey is set to a "live" key.
@@ -162,8 +172,8 @@ type PushReportFunc func(Feature, error)
 // attempt to push any feature in fs will be made. This constraint keeps plan
 // immutable.
 //
-// Each call to push is subject to rate limiting via the clients shared rate
-// limit.
+// Each call to push is subject to rate limiting via the Stripe client's shared
+// rate limiter.
 //
 // It returns the first error encountered if any.
 func (c *Client) Push(ctx context.Context, fs []Feature, cb PushReportFunc) error {
@@ -264,10 +274,7 @@ func (c *Client) pushFeature(ctx context.Context, f Feature) (providerID string,
 
 	// This will appear as the line item description in the Stripe dashboard
 	// and customer invoices.
-	data.Set("product_data", "name", fmt.Sprintf("%s - %s",
-		values.Coalesce(f.PlanTitle, f.String()),
-		values.Coalesce(f.Title, f.String()),
-	))
+	data.Set("product_data", "name", productName(f))
 
 	// secondary composite key in schedules:
 	data.Set("currency", f.Currency)
@@ -345,6 +352,14 @@ func (c *Client) pushFeature(ctx context.Context, f Feature) (providerID string,
 	return v.ID, err
 }
 
+// productName returns the name of the product Push creates for f.
+func productName(f Feature) string {
+	return fmt.Sprintf("%s - %s",
+		values.Coalesce(f.PlanTitle, f.String()),
+		values.Coalesce(f.Title, f.String()),
+	)
+}
+
 type stripePrice struct {
 	stripe.ID
 	LookupKey string `json:"lookup_key"`
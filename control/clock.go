@@ -11,13 +11,15 @@ import (
 )
 
 type stripeClock struct {
-	ID      string
+	stripe.ID
+	Name    string
 	Status  string
 	Present int64 `json:"frozen_time"`
 }
 
 type Clock struct {
 	id      string
+	name    string
 	present time.Time
 	status  string
 
@@ -45,15 +47,37 @@ func (c *Client) NewClock(ctx context.Context, name string, start time.Time) (*C
 	if err := c.Stripe.Do(ctx, "POST", "/v1/test_helpers/test_clocks", f, &v); err != nil {
 		return nil, err
 	}
+	return c.clockFromStripe(v), nil
+}
+
+// Clocks returns all test clocks in the Stripe account associated with the
+// client, newest first.
+func (c *Client) Clocks(ctx context.Context) ([]*Clock, error) {
+	// https://stripe.com/docs/api/test_clocks/list
+	scs, err := stripe.Slurp[stripeClock](ctx, c.Stripe, "GET", "/v1/test_helpers/test_clocks", stripe.Form{})
+	if err != nil {
+		return nil, err
+	}
+	clocks := make([]*Clock, len(scs))
+	for i, v := range scs {
+		clocks[i] = c.clockFromStripe(v)
+	}
+	return clocks, nil
+}
+
+func (c *Client) clockFromStripe(v stripeClock) *Clock {
 	return &Clock{
-		id:      v.ID,
+		id:      v.ProviderID(),
+		name:    v.Name,
 		present: time.Unix(v.Present, 0),
+		status:  v.Status,
 		sc:      c.Stripe,
 		logf:    c.Logf,
-	}, nil
+	}
 }
 
 func (c *Clock) ID() string         { return c.id }
+func (c *Clock) Name() string       { return c.name }
 func (c *Clock) Present() time.Time { return c.present }
 func (c *Clock) Status() string     { return c.status }
 
@@ -100,11 +124,18 @@ func (c *Clock) Sync(ctx context.Context) error {
 	if err := c.sc.Do(ctx, "GET", "/v1/test_helpers/test_clocks/"+c.ID(), f, &v); err != nil {
 		return err
 	}
+	c.name = v.Name
 	c.present = time.Unix(v.Present, 0)
 	c.status = v.Status
 	return nil
 }
 
+// Delete deletes the clock, along with all customers and subscriptions
+// created under it.
+func (c *Clock) Delete(ctx context.Context) error {
+	return c.sc.Do(ctx, "DELETE", "/v1/test_helpers/test_clocks/"+c.ID(), stripe.Form{}, nil)
+}
+
 func (c *Clock) vlogf(format string, args ...any) {
 	if c.logf != nil {
 		c.logf(format, args...)
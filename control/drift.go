@@ -0,0 +1,239 @@
+package control
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// DriftKind is the kind of a Drift.
+type DriftKind string
+
+const (
+	// DriftEdited is a price or product with a field that no longer matches
+	// what Push set for its feature.
+	DriftEdited DriftKind = "edited"
+
+	// DriftInactive is a price or product for a feature in the model that
+	// has been deactivated.
+	DriftInactive DriftKind = "inactive"
+
+	// DriftMissingPlan is a plan in the model without the sentinel product
+	// Push creates for each plan.
+	DriftMissingPlan DriftKind = "missing_plan"
+
+	// DriftMissingFeature is a feature in the model without a price.
+	DriftMissingFeature DriftKind = "missing_feature"
+
+	// DriftUnknownFeature is a price for a feature not in the model, but in
+	// a plan that is.
+	DriftUnknownFeature DriftKind = "unknown_feature"
+
+	// DriftUntagged is a price without tier.feature metadata that has a
+	// lookup key in the form Push uses for features.
+	DriftUntagged DriftKind = "untagged"
+)
+
+// A Drift is a difference between a pricing model and the prices and
+// products in Stripe.
+type Drift struct {
+	Kind       DriftKind
+	Plan       refs.Plan
+	Feature    refs.FeaturePlan // zero if the drift is not for a feature
+	ProviderID string           // the Stripe price or product ID, if any
+
+	// Field, Want, and Got are set for DriftEdited and DriftInactive. Field
+	// is the Stripe field, such as "metadata[tier.limit]" or
+	// "product.name".
+	Field     string
+	Want, Got string
+}
+
+type driftProduct struct {
+	stripe.ID
+	Name   string
+	Active bool
+}
+
+type driftPrice struct {
+	stripe.ID
+	Active    bool
+	LookupKey string `json:"lookup_key"`
+	Metadata  map[string]string
+	Product   driftProduct
+}
+
+// Drift compares the features in fs with the raw prices and products in
+// Stripe, including the metadata and fields Pull ignores, and returns the
+// differences found. Prices are immutable in Stripe apart from their
+// metadata, lookup key, and active flag, so only those, and the products
+// Push creates, are compared.
+func (c *Client) Drift(ctx context.Context, fs []Feature) ([]Drift, error) {
+	// https://stripe.com/docs/api/prices/list
+	var f stripe.Form
+	f.Add("expand[]", "data.product")
+	prices, err := stripe.Slurp[driftPrice](ctx, c.Stripe, "GET", "/v1/prices", f)
+	if err != nil {
+		return nil, err
+	}
+	// https://stripe.com/docs/api/products/list
+	products, err := stripe.Slurp[driftProduct](ctx, c.Stripe, "GET", "/v1/products", stripe.Form{})
+	if err != nil {
+		return nil, err
+	}
+	return findDrift(fs, prices, products), nil
+}
+
+func findDrift(fs []Feature, prices []driftPrice, products []driftProduct) []Drift {
+	byFeature := map[refs.FeaturePlan]driftPrice{}
+	byLookupKey := map[string]driftPrice{}
+	for _, p := range prices {
+		if fp, err := refs.ParseFeaturePlan(p.Metadata["tier.feature"]); err == nil {
+			byFeature[fp] = p
+		}
+		if p.LookupKey != "" {
+			byLookupKey[p.LookupKey] = p
+		}
+	}
+	productIDs := map[string]bool{}
+	for _, p := range products {
+		productIDs[p.ProviderID()] = true
+	}
+
+	var ds []Drift
+	plans := map[refs.Plan]bool{}
+	matched := map[string]bool{}
+	for _, f := range fs {
+		if !plans[f.Plan()] {
+			plans[f.Plan()] = true
+			if !productIDs[stripe.MakeID(f.Plan().String())] {
+				ds = append(ds, Drift{
+					Kind: DriftMissingPlan,
+					Plan: f.Plan(),
+				})
+			}
+		}
+
+		p, ok := byFeature[f.FeaturePlan]
+		if !ok {
+			p, ok = byLookupKey[f.ID()]
+		}
+		if !ok {
+			ds = append(ds, Drift{
+				Kind:    DriftMissingFeature,
+				Plan:    f.Plan(),
+				Feature: f.FeaturePlan,
+			})
+			continue
+		}
+		matched[p.ProviderID()] = true
+		ds = append(ds, priceDrift(f, p)...)
+	}
+
+	for _, p := range prices {
+		if matched[p.ProviderID()] {
+			continue
+		}
+		fp, err := refs.ParseFeaturePlan(p.Metadata["tier.feature"])
+		switch {
+		case err == nil && plans[fp.Plan()]:
+			ds = append(ds, Drift{
+				Kind:       DriftUnknownFeature,
+				Plan:       fp.Plan(),
+				Feature:    fp,
+				ProviderID: p.ProviderID(),
+			})
+		case err != nil && strings.HasPrefix(p.LookupKey, "tier__"):
+			ds = append(ds, Drift{
+				Kind:       DriftUntagged,
+				ProviderID: p.ProviderID(),
+				Field:      "lookup_key",
+				Got:        p.LookupKey,
+			})
+		}
+	}
+
+	slices.SortStableFunc(ds, func(a, b Drift) bool {
+		if a.Plan != b.Plan {
+			return a.Plan.Less(b.Plan)
+		}
+		return a.Feature.Less(b.Feature)
+	})
+	return ds
+}
+
+// priceDrift returns the differences between p and the price Push creates
+// for f.
+func priceDrift(f Feature, p driftPrice) []Drift {
+	var ds []Drift
+	edited := func(field, want, got string) {
+		ds = append(ds, Drift{
+			Kind:       DriftEdited,
+			Plan:       f.Plan(),
+			Feature:    f.FeaturePlan,
+			ProviderID: p.ProviderID(),
+			Field:      field,
+			Want:       want,
+			Got:        got,
+		})
+	}
+	inactive := func(field, id string) {
+		ds = append(ds, Drift{
+			Kind:       DriftInactive,
+			Plan:       f.Plan(),
+			Feature:    f.FeaturePlan,
+			ProviderID: id,
+			Field:      field,
+			Want:       "true",
+			Got:        "false",
+		})
+	}
+
+	if !p.Active {
+		inactive("active", p.ProviderID())
+	}
+	if p.LookupKey != f.ID() {
+		edited("lookup_key", f.ID(), p.LookupKey)
+	}
+
+	meta := func(key, want string) {
+		if got := p.Metadata[key]; got != want {
+			edited("metadata["+key+"]", want, got)
+		}
+	}
+	meta("tier.feature", f.FeaturePlan.String())
+	meta("tier.plan_title", f.PlanTitle)
+	meta("tier.title", f.Title)
+	if f.IsMetered() {
+		// compare parsed limits since Push writes Inf as a number, and
+		// Pull also accepts "inf"
+		if got := p.Metadata["tier.limit"]; parseLimit(got) != f.Limit() {
+			edited("metadata[tier.limit]", formatLimit(f.Limit()), got)
+		}
+	} else if got, ok := p.Metadata["tier.limit"]; ok {
+		edited("metadata[tier.limit]", "", got)
+	}
+
+	if p.Product.ProviderID() != f.ID() {
+		edited("product", f.ID(), p.Product.ProviderID())
+		return ds
+	}
+	if !p.Product.Active {
+		inactive("product.active", p.Product.ProviderID())
+	}
+	if want := productName(f); p.Product.Name != want {
+		edited("product.name", want, p.Product.Name)
+	}
+	return ds
+}
+
+func formatLimit(n int) string {
+	if n == Inf {
+		return "inf"
+	}
+	return strconv.Itoa(n)
+}
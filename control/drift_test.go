@@ -0,0 +1,121 @@
+package control
+
+import (
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/stripe"
+)
+
+func TestFindDrift(t *testing.T) {
+	api := Feature{
+		FeaturePlan: mpf("feature:api@plan:pro@2"),
+		PlanTitle:   "Pro",
+		Title:       "API",
+		Aggregate:   "sum",
+		Mode:        "graduated",
+		Tiers:       []Tier{{Upto: 1000}},
+	}
+	base := Feature{
+		FeaturePlan: mpf("feature:base@plan:pro@2"),
+		PlanTitle:   "Pro",
+		Title:       "Base",
+		Base:        4900,
+	}
+	seats := Feature{
+		FeaturePlan: mpf("feature:seats@plan:team@1"),
+		Aggregate:   "max",
+		Tiers:       []Tier{{Upto: Inf, Price: 10}},
+	}
+	fs := []Feature{api, base, seats}
+
+	price := func(id string, f Feature, meta map[string]string) driftPrice {
+		p := driftPrice{
+			Active:    true,
+			LookupKey: f.ID(),
+			Metadata: map[string]string{
+				"tier.feature":    f.FeaturePlan.String(),
+				"tier.plan_title": f.PlanTitle,
+				"tier.title":      f.Title,
+			},
+			Product: driftProduct{Name: productName(f), Active: true},
+		}
+		p.ID = stripe.ID(id)
+		p.Product.ID = stripe.ID(f.ID())
+		for k, v := range meta {
+			if v == "" {
+				delete(p.Metadata, k)
+			} else {
+				p.Metadata[k] = v
+			}
+		}
+		return p
+	}
+	product := func(id string) driftProduct {
+		var p driftProduct
+		p.ID = stripe.ID(id)
+		return p
+	}
+
+	t.Run("none", func(t *testing.T) {
+		prices := []driftPrice{
+			price("price_api", api, map[string]string{"tier.limit": "1000"}),
+			price("price_base", base, nil),
+			price("price_seats", seats, map[string]string{"tier.limit": "9223372036854775807"}),
+		}
+		products := []driftProduct{
+			product("tier__plan-pro-2"),
+			product("tier__plan-team-1"),
+		}
+		diff.Test(t, t.Errorf, findDrift(fs, prices, products), []Drift(nil))
+	})
+
+	t.Run("drift", func(t *testing.T) {
+		inactive := price("price_base", base, nil)
+		inactive.Active = false
+		inactive.Product.Name = "Pro Base"
+
+		extra := Feature{FeaturePlan: mpf("feature:extra@plan:pro@2")}
+		untagged := price("price_untagged", extra, map[string]string{"tier.feature": ""})
+		untagged.LookupKey = "tier__feature-extra-plan-pro-2"
+
+		prices := []driftPrice{
+			price("price_api", api, map[string]string{
+				"tier.limit": "2000",
+				"tier.title": "Calls",
+			}),
+			inactive,
+			price("price_extra", Feature{FeaturePlan: mpf("feature:other@plan:team@1")}, nil),
+			untagged,
+			price("price_unrelated", Feature{FeaturePlan: mpf("feature:x@plan:gone@1")}, nil),
+		}
+		products := []driftProduct{
+			product("tier__plan-pro-2"),
+		}
+
+		want := []Drift{{
+			Kind: DriftUntagged, ProviderID: "price_untagged",
+			Field: "lookup_key", Got: "tier__feature-extra-plan-pro-2",
+		}, {
+			Kind: DriftEdited, Plan: mpp("plan:pro@2"), Feature: mpf("feature:api@plan:pro@2"),
+			ProviderID: "price_api", Field: "metadata[tier.title]", Want: "API", Got: "Calls",
+		}, {
+			Kind: DriftEdited, Plan: mpp("plan:pro@2"), Feature: mpf("feature:api@plan:pro@2"),
+			ProviderID: "price_api", Field: "metadata[tier.limit]", Want: "1000", Got: "2000",
+		}, {
+			Kind: DriftInactive, Plan: mpp("plan:pro@2"), Feature: mpf("feature:base@plan:pro@2"),
+			ProviderID: "price_base", Field: "active", Want: "true", Got: "false",
+		}, {
+			Kind: DriftEdited, Plan: mpp("plan:pro@2"), Feature: mpf("feature:base@plan:pro@2"),
+			ProviderID: "price_base", Field: "product.name", Want: "Pro - Base", Got: "Pro Base",
+		}, {
+			Kind: DriftMissingPlan, Plan: mpp("plan:team@1"),
+		}, {
+			Kind: DriftUnknownFeature, Plan: mpp("plan:team@1"), Feature: mpf("feature:other@plan:team@1"),
+			ProviderID: "price_extra",
+		}, {
+			Kind: DriftMissingFeature, Plan: mpp("plan:team@1"), Feature: mpf("feature:seats@plan:team@1"),
+		}}
+		diff.Test(t, t.Errorf, findDrift(fs, prices, products), want)
+	})
+}
@@ -0,0 +1,184 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/refs"
+)
+
+// Errors
+var (
+	ErrMixedCurrencies = errors.New("features have different currencies")
+	ErrNegativeUsage   = errors.New("usage must not be negative")
+)
+
+// zeroDecimalCurrencies are the currencies Stripe represents in whole units
+// rather than hundredths.
+//
+// See https://stripe.com/docs/currencies#zero-decimal.
+var zeroDecimalCurrencies = []string{
+	"bif", "clp", "djf", "gnf", "jpy", "kmf", "krw", "mga",
+	"pyg", "rwf", "ugx", "vnd", "vuv", "xaf", "xof", "xpf",
+}
+
+// threeDecimalCurrencies are the currencies Stripe represents in thousandths.
+//
+// See https://stripe.com/docs/currencies#three-decimal.
+var threeDecimalCurrencies = []string{"bhd", "jod", "kwd", "omr", "tnd"}
+
+// CurrencyDecimals reports the number of decimal places in the smallest unit
+// of currency, as used by Stripe for amounts and prices.
+func CurrencyDecimals(currency string) int {
+	switch {
+	case slices.Contains(zeroDecimalCurrencies, currency):
+		return 0
+	case slices.Contains(threeDecimalCurrencies, currency):
+		return 3
+	}
+	return 2
+}
+
+// FormatAmount formats amount, in the smallest unit of currency, in the
+// major unit of currency. For example, 1234 "usd" is formatted as "12.34",
+// and 1234 "jpy" as "1234".
+func FormatAmount(amount int, currency string) string {
+	d := CurrencyDecimals(currency)
+	return strconv.FormatFloat(float64(amount)/math.Pow10(d), 'f', d, 64)
+}
+
+// A QuoteLine is the cost of a feature in a Quote.
+type QuoteLine struct {
+	Feature refs.FeaturePlan
+
+	// Quantity is the usage quoted for. It is always 1 for licensed
+	// features.
+	Quantity int
+
+	// Billed is the quantity billed after applying the feature's
+	// transform, if any.
+	Billed int
+
+	// Amount is the cost of the feature in the smallest unit of the
+	// currency.
+	Amount int
+}
+
+// A Quote is the cost of a set of features for an amount of usage over one
+// billing period, computed as Stripe would on an invoice.
+type Quote struct {
+	Currency string
+	Lines    []QuoteLine
+	Total    int
+}
+
+// QuoteFeatures computes the cost of the features fs for one billing period
+// with the usage in usage, keyed by feature name. Metered features without
+// usage are quoted for a quantity of zero. Licensed features are always
+// quoted for a quantity of one, and usage of them is ignored.
+//
+// It returns an error if usage names a feature not in fs, if any usage is
+// negative, or if the features are not all in the same currency.
+func QuoteFeatures(fs []Feature, usage map[refs.Name]int) (*Quote, error) {
+	for name, n := range usage {
+		if !slices.ContainsFunc(fs, func(f Feature) bool { return f.Name() == name }) {
+			return nil, fmt.Errorf("%w found named %q", ErrFeatureNotFound, name)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%w: %s=%d", ErrNegativeUsage, name, n)
+		}
+	}
+
+	q := &Quote{}
+	for _, f := range fs {
+		if q.Currency == "" {
+			q.Currency = f.Currency
+		}
+		if f.Currency != q.Currency {
+			return nil, fmt.Errorf("%w: %q and %q", ErrMixedCurrencies, q.Currency, f.Currency)
+		}
+		line := f.Quote(usage[f.Name()])
+		q.Lines = append(q.Lines, line)
+		q.Total += line.Amount
+	}
+	return q, nil
+}
+
+// Quote returns the cost of n units of f for one billing period.
+//
+// Amounts are rounded to the nearest smallest unit of the currency per tier,
+// as Stripe does for each tier of a graduated price.
+func (f *Feature) Quote(n int) QuoteLine {
+	line := QuoteLine{Feature: f.FeaturePlan}
+	if !f.IsMetered() {
+		line.Quantity = 1
+		line.Billed = 1
+		line.Amount = roundAmount(f.Base)
+		return line
+	}
+
+	line.Quantity = n
+	line.Billed = f.transform(n)
+	if line.Billed == 0 {
+		return line
+	}
+
+	switch f.Mode {
+	case "volume":
+		t := f.Tiers[f.tierFor(line.Billed)]
+		line.Amount = roundAmount(float64(line.Billed)*t.Price) + t.Base
+	default: // graduated
+		prev := 0
+		for i, t := range f.Tiers {
+			upto := t.Upto
+			if i == len(f.Tiers)-1 {
+				upto = Inf // Stripe bills the last tier without limit
+			}
+			units := min(line.Billed, upto) - prev
+			if units <= 0 {
+				break
+			}
+			line.Amount += roundAmount(float64(units)*t.Price) + t.Base
+			prev = upto
+		}
+	}
+	return line
+}
+
+// transform returns n divided by the feature's transform denominator and
+// rounded as configured. It returns n if the feature has no transform.
+func (f *Feature) transform(n int) int {
+	d := f.TransformDenominator
+	if d <= 0 {
+		return n
+	}
+	if f.TransformRoundUp {
+		return (n + d - 1) / d
+	}
+	return n / d
+}
+
+// tierFor returns the index of the tier n falls in. Quantities beyond the
+// last tier fall in the last tier.
+func (f *Feature) tierFor(n int) int {
+	for i, t := range f.Tiers {
+		if n <= t.Upto {
+			return i
+		}
+	}
+	return len(f.Tiers) - 1
+}
+
+func roundAmount(v float64) int {
+	return int(math.Round(v))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
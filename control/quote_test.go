@@ -0,0 +1,257 @@
+package control
+
+import (
+	"errors"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+)
+
+func TestFeatureQuote(t *testing.T) {
+	featureX := mpf("feature:x@plan:test@0")
+
+	graduated := []Tier{
+		{Upto: 10, Price: 0, Base: 100},
+		{Upto: 100, Price: 2},
+		{Upto: Inf, Price: 0.5, Base: 1000},
+	}
+
+	cases := []struct {
+		name string
+		f    Feature
+		n    int
+		want QuoteLine
+	}{
+		// The amounts below mirror invoices in schedule_test.go.
+		{
+			name: "per unit",
+			f:    Feature{Aggregate: "sum", Mode: "graduated", Tiers: []Tier{{Upto: Inf, Price: 1}}},
+			n:    2,
+			want: QuoteLine{Quantity: 2, Billed: 2, Amount: 2},
+		},
+		{
+			name: "flat fee",
+			f:    Feature{Aggregate: "sum", Mode: "graduated", Tiers: []Tier{{Upto: Inf, Base: 1000}}},
+			n:    99,
+			want: QuoteLine{Quantity: 99, Billed: 99, Amount: 1000},
+		},
+		{
+			name: "flat fee without usage",
+			f:    Feature{Aggregate: "sum", Mode: "graduated", Tiers: []Tier{{Upto: Inf, Base: 1000}}},
+			n:    0,
+			want: QuoteLine{},
+		},
+		{
+			name: "licensed",
+			f:    Feature{Base: 31 * 1000},
+			n:    7,
+			want: QuoteLine{Quantity: 1, Billed: 1, Amount: 31000},
+		},
+		{
+			name: "transform round up",
+			f: Feature{
+				Aggregate:            "sum",
+				Mode:                 "graduated",
+				Tiers:                []Tier{{Upto: Inf, Price: 2}},
+				TransformDenominator: 3,
+				TransformRoundUp:     true,
+			},
+			n:    100,
+			want: QuoteLine{Quantity: 100, Billed: 34, Amount: 68},
+		},
+		{
+			name: "transform round down",
+			f: Feature{
+				Aggregate:            "sum",
+				Mode:                 "graduated",
+				Tiers:                []Tier{{Upto: Inf, Price: 2}},
+				TransformDenominator: 3,
+			},
+			n:    100,
+			want: QuoteLine{Quantity: 100, Billed: 33, Amount: 66},
+		},
+		{
+			name: "graduated first tier",
+			f:    Feature{Aggregate: "sum", Mode: "graduated", Tiers: graduated},
+			n:    5,
+			want: QuoteLine{Quantity: 5, Billed: 5, Amount: 100},
+		},
+		{
+			name: "graduated all tiers",
+			f:    Feature{Aggregate: "sum", Mode: "graduated", Tiers: graduated},
+			n:    1_200_000,
+			// 100 + 90*2 + (1_200_000-100)*0.5 + 1000
+			want: QuoteLine{Quantity: 1_200_000, Billed: 1_200_000, Amount: 100 + 180 + 599_950 + 1000},
+		},
+		{
+			name: "volume",
+			f:    Feature{Aggregate: "sum", Mode: "volume", Tiers: graduated},
+			n:    50,
+			want: QuoteLine{Quantity: 50, Billed: 50, Amount: 100},
+		},
+		{
+			name: "volume last tier",
+			f:    Feature{Aggregate: "sum", Mode: "volume", Tiers: graduated},
+			n:    1000,
+			want: QuoteLine{Quantity: 1000, Billed: 1000, Amount: 500 + 1000},
+		},
+		{
+			name: "beyond limit",
+			f:    Feature{Aggregate: "sum", Mode: "graduated", Tiers: []Tier{{Upto: 10, Price: 3}}},
+			n:    20,
+			want: QuoteLine{Quantity: 20, Billed: 20, Amount: 60},
+		},
+		{
+			name: "decimal price",
+			f:    Feature{Aggregate: "sum", Mode: "graduated", Tiers: []Tier{{Upto: Inf, Price: 0.015}}},
+			n:    1234,
+			want: QuoteLine{Quantity: 1234, Billed: 1234, Amount: 19}, // 18.51
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f.FeaturePlan = featureX
+			tt.want.Feature = featureX
+			got := tt.f.Quote(tt.n)
+			diff.Test(t, t.Errorf, got, tt.want)
+		})
+	}
+}
+
+func TestQuoteFeatures(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:api@plan:pro@2"),
+		Currency:    "jpy",
+		Aggregate:   "sum",
+		Mode:        "graduated",
+		Tiers:       []Tier{{Upto: 1000}, {Upto: Inf, Price: 0.1}},
+	}, {
+		FeaturePlan: mpf("feature:base@plan:pro@2"),
+		Currency:    "jpy",
+		Base:        5000,
+	}}
+
+	got, err := QuoteFeatures(fs, map[refs.Name]int{
+		mpn("feature:api"): 1_200_000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, &Quote{
+		Currency: "jpy",
+		Lines: []QuoteLine{
+			{Feature: mpf("feature:api@plan:pro@2"), Quantity: 1_200_000, Billed: 1_200_000, Amount: 119_900},
+			{Feature: mpf("feature:base@plan:pro@2"), Quantity: 1, Billed: 1, Amount: 5000},
+		},
+		Total: 124_900,
+	})
+
+	_, err = QuoteFeatures(fs, map[refs.Name]int{mpn("feature:nope"): 1})
+	if !errors.Is(err, ErrFeatureNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrFeatureNotFound)
+	}
+	_, err = QuoteFeatures(fs, map[refs.Name]int{mpn("feature:api"): -1})
+	if !errors.Is(err, ErrNegativeUsage) {
+		t.Errorf("err = %v, want %v", err, ErrNegativeUsage)
+	}
+
+	fs[1].Currency = "usd"
+	_, err = QuoteFeatures(fs, nil)
+	if !errors.Is(err, ErrMixedCurrencies) {
+		t.Errorf("err = %v, want %v", err, ErrMixedCurrencies)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		amount   int
+		currency string
+		want     string
+	}{
+		{1234, "usd", "12.34"},
+		{5, "eur", "0.05"},
+		{1234, "jpy", "1234"},
+		{1234, "krw", "1234"},
+		{1234, "kwd", "1.234"},
+		{-250, "usd", "-2.50"},
+	}
+	for _, tt := range cases {
+		if got := FormatAmount(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("FormatAmount(%d, %q) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+// TestQuoteMatchesInvoices checks quotes against the amounts Stripe
+// invoices for the same usage.
+func TestQuoteMatchesInvoices(t *testing.T) {
+	s := newScheduleTester(t)
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:graduated@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers: []Tier{
+			{Upto: 10, Base: 100},
+			{Upto: 100, Price: 2},
+			{Upto: Inf, Price: 0.5, Base: 1000},
+		},
+	}, {
+		FeaturePlan: mpf("feature:volume@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "volume",
+		Aggregate:   "sum",
+		Tiers: []Tier{
+			{Upto: 10, Price: 5},
+			{Upto: Inf, Price: 3, Base: 10},
+		},
+	}, {
+		FeaturePlan:          mpf("feature:transform@plan:test@0"),
+		Interval:             "@monthly",
+		Currency:             "usd",
+		Mode:                 "graduated",
+		Aggregate:            "sum",
+		Tiers:                []Tier{{Upto: Inf, Price: 2}},
+		TransformDenominator: 3,
+		TransformRoundUp:     true,
+	}}
+	usage := map[refs.Name]int{
+		mpn("feature:graduated"): 1234,
+		mpn("feature:volume"):    42,
+		mpn("feature:transform"): 100,
+	}
+
+	s.push(fs)
+	s.setPaymentMethod("org:paid", "pm_card_us")
+	s.schedule("org:paid", 0, "", FeaturePlans(fs)...)
+	for name, n := range usage {
+		s.report("org:paid", name.String(), n)
+	}
+	s.advanceToNextPeriod(1)
+
+	invoices, err := s.cc.LookupInvoices(s.ctx, "org:paid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[refs.FeaturePlan]int{}
+	for _, in := range invoices {
+		for _, line := range in.Lines {
+			got[line.Feature] += int(line.Amount)
+		}
+	}
+
+	q, err := QuoteFeatures(fs, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[refs.FeaturePlan]int{}
+	for _, line := range q.Lines {
+		want[line.Feature] = line.Amount
+	}
+	diff.Test(t, t.Errorf, got, want)
+}
@@ -0,0 +1,223 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"tier.run/mirror/x/exp/slices"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// PlanRevenue is the monthly recurring revenue (MRR) from the features of a
+// plan in one currency. Amounts are in the currency's smallest unit.
+type PlanRevenue struct {
+	Plan     refs.Plan
+	Currency string
+	Orgs     int // the number of orgs subscribed to features in the plan
+	Licensed int // from licensed features, normalized to a month
+	Metered  int // estimated from the last closed invoice, normalized to a month
+}
+
+// MRR returns the total monthly recurring revenue for the plan.
+func (r PlanRevenue) MRR() int {
+	return r.Licensed + r.Metered
+}
+
+// paying is the set of subscription statuses counted as revenue. Trialing
+// subscriptions are not paying yet, and canceled and incomplete
+// subscriptions are not paying at all.
+var paying = []string{"active", "past_due"}
+
+type revenueSubscription struct {
+	stripe.ID
+	Status   string
+	Metadata struct {
+		Name string `json:"tier.subscription"`
+	}
+	Items struct {
+		Data []struct {
+			Price    stripePrice
+			Quantity int
+		}
+	}
+}
+
+// Revenue reports the monthly recurring revenue of each plan with paying
+// subscriptions created by Tier. Licensed features are counted at their
+// price and quantity. Metered features are estimated from the most recent
+// closed invoice for each subscription. Both are normalized to a month
+// from the billing interval of their price. Discounts and taxes are not
+// included.
+func (c *Client) Revenue(ctx context.Context) ([]PlanRevenue, error) {
+	// https://stripe.com/docs/api/subscriptions/list
+	var f stripe.Form
+	if clockID := clockFromContext(ctx); clockID != "" {
+		f.Set("test_clock", clockID)
+	}
+	subs, err := stripe.Slurp[revenueSubscription](ctx, c.Stripe, "GET", "/v1/subscriptions", f)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	lines := map[string][]stripeInvoiceLineItem{}
+	var g errgroup.Group
+	g.SetLimit(c.maxWorkers())
+	for _, s := range subs {
+		if !isRevenue(s) || !hasMetered(s) {
+			continue
+		}
+		s := s
+		g.Go(func() error {
+			ls, err := c.lastInvoiceLines(ctx, s.ProviderID())
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			lines[s.ProviderID()] = ls
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return revenue(subs, lines), nil
+}
+
+// lastInvoiceLines returns the lines of the most recent closed invoice for
+// the subscription with the provided ID, or nil if there is none.
+func (c *Client) lastInvoiceLines(ctx context.Context, subID string) ([]stripeInvoiceLineItem, error) {
+	// https://stripe.com/docs/api/invoices/list
+	type T struct {
+		stripe.ID
+		Status string
+	}
+	var f stripe.Form
+	f.Set("subscription", subID)
+	in, err := stripe.List[T](ctx, c.Stripe, "GET", "/v1/invoices", f).Find(func(in T) bool {
+		return in.Status == "paid" || in.Status == "open" || in.Status == "uncollectible"
+	})
+	if errors.Is(err, stripe.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// https://stripe.com/docs/api/invoices/invoice_lines
+	type L struct {
+		stripe.ID
+		stripeInvoiceLineItem
+	}
+	ls, err := stripe.Slurp[L](ctx, c.Stripe, "GET", "/v1/invoices/"+in.ProviderID()+"/lines", stripe.Form{})
+	if err != nil {
+		return nil, err
+	}
+	var out []stripeInvoiceLineItem
+	for _, l := range ls {
+		out = append(out, l.stripeInvoiceLineItem)
+	}
+	return out, nil
+}
+
+func isRevenue(s revenueSubscription) bool {
+	return s.Metadata.Name != "" && slices.Contains(paying, s.Status)
+}
+
+func hasMetered(s revenueSubscription) bool {
+	for _, it := range s.Items.Data {
+		if it.Price.Recurring.UsageType == "metered" {
+			return true
+		}
+	}
+	return false
+}
+
+// revenue sums the licensed items of subs, and the metered lines of the last
+// invoice for each, by plan and currency.
+func revenue(subs []revenueSubscription, lines map[string][]stripeInvoiceLineItem) []PlanRevenue {
+	type key struct {
+		plan     refs.Plan
+		currency string
+	}
+	type sum struct {
+		orgs              map[string]bool
+		licensed, metered float64
+	}
+	sums := map[key]*sum{}
+	add := func(subID string, p stripePrice, licensed, metered float64) {
+		k := key{p.Metadata.Feature.Plan(), p.Currency}
+		s := sums[k]
+		if s == nil {
+			s = &sum{orgs: map[string]bool{}}
+			sums[k] = s
+		}
+		s.orgs[subID] = true
+		f := monthlyFactor(p.Recurring.Interval, p.Recurring.IntervalCount)
+		s.licensed += licensed * f
+		s.metered += metered * f
+	}
+
+	for _, s := range subs {
+		if !isRevenue(s) {
+			continue
+		}
+		for _, it := range s.Items.Data {
+			p := it.Price
+			if p.Metadata.Feature.IsZero() || p.Recurring.UsageType == "metered" {
+				continue
+			}
+			add(s.ProviderID(), p, p.UnitAmount*float64(it.Quantity), 0)
+		}
+		for _, l := range lines[s.ProviderID()] {
+			p := l.Price
+			if p.Metadata.Feature.IsZero() || p.Recurring.UsageType != "metered" {
+				continue
+			}
+			add(s.ProviderID(), p, 0, l.Amount)
+		}
+	}
+
+	var rs []PlanRevenue
+	for k, s := range sums {
+		rs = append(rs, PlanRevenue{
+			Plan:     k.plan,
+			Currency: k.currency,
+			Orgs:     len(s.orgs),
+			Licensed: int(math.Round(s.licensed)),
+			Metered:  int(math.Round(s.metered)),
+		})
+	}
+	slices.SortFunc(rs, func(a, b PlanRevenue) bool {
+		if a.Plan != b.Plan {
+			return a.Plan.Less(b.Plan)
+		}
+		return a.Currency < b.Currency
+	})
+	return rs
+}
+
+// monthlyFactor returns the factor that converts an amount billed every
+// count intervals to a monthly amount.
+func monthlyFactor(interval string, count int) float64 {
+	if count < 1 {
+		count = 1
+	}
+	var perYear float64
+	switch interval {
+	case "day":
+		perYear = 365
+	case "week":
+		perYear = 52
+	case "month":
+		perYear = 12
+	case "year":
+		perYear = 1
+	}
+	return perYear / 12 / float64(count)
+}
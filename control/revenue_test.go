@@ -0,0 +1,114 @@
+package control
+
+import (
+	"encoding/json"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestRevenue(t *testing.T) {
+	var subs []revenueSubscription
+	if err := json.Unmarshal([]byte(`[{
+		"id": "sub_a",
+		"status": "active",
+		"metadata": {"tier.subscription": "default"},
+		"items": {"data": [{
+			"quantity": 1,
+			"price": {
+				"metadata": {"tier.feature": "feature:base@plan:pro@2"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "interval_count": 1, "usage_type": "licensed"},
+				"unit_amount_decimal": "4900"
+			}
+		}, {
+			"quantity": 0,
+			"price": {
+				"metadata": {"tier.feature": "feature:api@plan:pro@2"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "interval_count": 1, "usage_type": "metered"}
+			}
+		}]}
+	}, {
+		"id": "sub_b",
+		"status": "past_due",
+		"metadata": {"tier.subscription": "default"},
+		"items": {"data": [{
+			"quantity": 3,
+			"price": {
+				"metadata": {"tier.feature": "feature:seats@plan:team@1"},
+				"currency": "eur",
+				"recurring": {"interval": "year", "interval_count": 1, "usage_type": "licensed"},
+				"unit_amount_decimal": "12000"
+			}
+		}, {
+			"quantity": 1,
+			"price": {
+				"metadata": {"tier.feature": "feature:base@plan:pro@2"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "interval_count": 1, "usage_type": "licensed"},
+				"unit_amount_decimal": "4900"
+			}
+		}]}
+	}, {
+		"id": "sub_trial",
+		"status": "trialing",
+		"metadata": {"tier.subscription": "default"},
+		"items": {"data": [{
+			"quantity": 1,
+			"price": {
+				"metadata": {"tier.feature": "feature:base@plan:pro@2"},
+				"currency": "usd",
+				"recurring": {"interval": "month", "usage_type": "licensed"},
+				"unit_amount_decimal": "4900"
+			}
+		}]}
+	}, {
+		"id": "sub_other",
+		"status": "active",
+		"items": {"data": [{
+			"quantity": 1,
+			"price": {
+				"currency": "usd",
+				"recurring": {"interval": "month", "usage_type": "licensed"},
+				"unit_amount_decimal": "100000"
+			}
+		}]}
+	}]`), &subs); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []stripeInvoiceLineItem
+	if err := json.Unmarshal([]byte(`[{
+		"amount": 2000,
+		"price": {
+			"metadata": {"tier.feature": "feature:api@plan:pro@2"},
+			"currency": "usd",
+			"recurring": {"interval": "week", "interval_count": 2, "usage_type": "metered"}
+		}
+	}, {
+		"amount": 4900,
+		"price": {
+			"metadata": {"tier.feature": "feature:base@plan:pro@2"},
+			"currency": "usd",
+			"recurring": {"interval": "month", "interval_count": 1, "usage_type": "licensed"}
+		}
+	}]`), &lines); err != nil {
+		t.Fatal(err)
+	}
+
+	got := revenue(subs, map[string][]stripeInvoiceLineItem{"sub_a": lines})
+	diff.Test(t, t.Errorf, got, []PlanRevenue{{
+		Plan:     mpp("plan:pro@2"),
+		Currency: "usd",
+		Orgs:     2,
+		Licensed: 9800,
+		Metered:  4333, // 2000 every 2 weeks
+	}, {
+		Plan:     mpp("plan:team@1"),
+		Currency: "eur",
+		Orgs:     1,
+		Licensed: 3000, // 3 seats at 12000 a year
+	}})
+	diff.Test(t, t.Errorf, got[0].MRR(), 14133)
+}
@@ -273,7 +273,7 @@ func (c *Client) createSchedule(ctx context.Context, org, name string, fromSub s
 			f.Set("default_settings", "default_payment_method", p.PaymentMethod)
 		}
 		f.Set("customer", cid)
-		if err := addPhases(ctx, c, &f, false, name, p.Phases); err != nil {
+		if err := addPhases(ctx, c, &f, false, name, p.Phases, p.Model); err != nil {
 			return err
 		}
 		_, _, err = create(f)
@@ -457,7 +457,7 @@ func (c *Client) updateSchedule(ctx context.Context, schedID, name string, p Sch
 	if p.PaymentMethod != "" {
 		f.Set("default_settings", "default_payment_method", p.PaymentMethod)
 	}
-	if err := addPhases(ctx, c, &f, true, name, p.Phases); err != nil {
+	if err := addPhases(ctx, c, &f, true, name, p.Phases, p.Model); err != nil {
 		return err
 	}
 	return c.Stripe.Do(ctx, "POST", "/v1/subscription_schedules/"+schedID, f, nil)
@@ -476,7 +476,7 @@ func (c *Client) cancelSubscription(ctx context.Context, subID string) (err erro
 	return c.Stripe.Do(ctx, "DELETE", "/v1/subscriptions/"+subID, f, nil)
 }
 
-func addPhases(ctx context.Context, c *Client, f *stripe.Form, update bool, name string, phases []Phase) error {
+func addPhases(ctx context.Context, c *Client, f *stripe.Form, update bool, name string, phases []Phase, model []Feature) error {
 	var automaticTax bool
 	for i, p := range phases {
 		if i > 0 && p.AutomaticTax != automaticTax {
@@ -499,7 +499,7 @@ func addPhases(ctx context.Context, c *Client, f *stripe.Form, update bool, name
 			return nil
 		}
 
-		fs, err := c.lookupFeatures(ctx, p.Features)
+		fs, err := c.phaseFeatures(ctx, model, p.Features)
 		if err != nil {
 			return err
 		}
@@ -603,6 +603,10 @@ func (c *Client) Checkout(ctx context.Context, org string, successURL string, p
 type ScheduleParams struct {
 	PaymentMethod string
 	Phases        []Phase
+
+	// Model, if not nil, is the pricing model the features of each phase
+	// are found in, instead of looking them up in Stripe.
+	Model []Feature
 }
 
 func (c *Client) Schedule(ctx context.Context, org string, p ScheduleParams) error {
@@ -715,6 +719,26 @@ func (c *Client) SubscribeTo(ctx context.Context, org string, fs []refs.FeatureP
 
 }
 
+// phaseFeatures returns the features for keys from model, or from Stripe if
+// model is nil or lacks any of them, such as when model is a cached model
+// from before the features were pushed.
+func (c *Client) phaseFeatures(ctx context.Context, model []Feature, keys []refs.FeaturePlan) ([]Feature, error) {
+	if model == nil {
+		return c.lookupFeatures(ctx, keys)
+	}
+	fs := make([]Feature, 0, len(keys))
+	for _, k := range keys {
+		i := slices.IndexFunc(model, func(f Feature) bool {
+			return f.FeaturePlan == k
+		})
+		if i < 0 {
+			return c.lookupFeatures(ctx, keys)
+		}
+		fs = append(fs, model[i])
+	}
+	return fs, nil
+}
+
 func (c *Client) lookupFeatures(ctx context.Context, keys []refs.FeaturePlan) ([]Feature, error) {
 	if len(keys) == 0 {
 		return nil, errors.New("lookupFeatures: no features provided")
@@ -851,9 +875,10 @@ type Period struct {
 }
 
 type Invoice struct {
-	Amount float64
-	Period Period
-	Lines  []InvoiceLineItem
+	Amount   float64
+	Currency string
+	Period   Period
+	Lines    []InvoiceLineItem
 
 	SubtotalPreTax int
 	Subtotal       int
@@ -904,6 +929,7 @@ func (c *Client) LookupInvoices(ctx context.Context, org string) ([]Invoice, err
 	type T struct {
 		// https://stripe.com/docs/api/invoices/object
 		stripe.ID
+		Currency             string
 		PeriodStart          int64 `json:"period_start"`
 		PeriodEnd            int64 `json:"period_end"`
 		SubtotalExcludingTax int   `json:"subtotal_excluding_tax"`
@@ -936,6 +962,7 @@ func (c *Client) LookupInvoices(ctx context.Context, org string) ([]Invoice, err
 			})
 		}
 		ins = append(ins, Invoice{
+			Currency: in.Currency,
 			Period: Period{
 				Effective: time.Unix(in.PeriodStart, 0),
 				End:       time.Unix(in.PeriodEnd, 0),
@@ -1030,6 +1057,13 @@ type stripeCustomer struct {
 	}
 }
 
+// isSearchUnsupported reports if e means customer search is not supported
+// for the account, such as in regions where Stripe does not offer it, as
+// opposed to a failure of one search.
+func isSearchUnsupported(e *stripe.Error) bool {
+	return e.Type == "invalid_request_error" && (e.Status == 400 || e.Status == 404)
+}
+
 func (c *Client) WhoIs(ctx context.Context, org string) (id string, err error) {
 	defer errorfmt.Handlef("whois: %q: %w", org, &err)
 	if !strings.HasPrefix(org, "org:") {
@@ -1044,7 +1078,31 @@ func (c *Client) WhoIs(ctx context.Context, org string) (id string, err error) {
 		clock:   clockID,
 	}
 
-	cid, err := c.cache.load(key, func() (string, error) {
+	cid, err := c.cache.lookup(c.Cache, key, func() (string, error) {
+		// Search does not filter by test clock, so only use it outside
+		// of one.
+		if clockID == "" && !c.searchUnavailable.Load() {
+			cid, err := c.searchCustomer(ctx, org)
+			if err == nil {
+				return cid, nil
+			}
+			// Search is eventually consistent, so customers created
+			// in the last minute or so may not be found yet. Fall
+			// back to listing for orgs not found, and if search
+			// fails. Only stop searching if search is unsupported,
+			// and not after errors such as rate limits.
+			var e *stripe.Error
+			switch {
+			case errors.As(err, &e):
+				c.Logf("stripe: customer search: %v; falling back to listing", err)
+				if isSearchUnsupported(e) {
+					c.searchUnavailable.Store(true)
+				}
+			case !errors.Is(err, stripe.ErrNotFound):
+				return "", err
+			}
+		}
+
 		var f stripe.Form
 		if clockID != "" {
 			f.Set("test_clock", clockID)
@@ -1064,6 +1122,29 @@ func (c *Client) WhoIs(ctx context.Context, org string) (id string, err error) {
 	return cid, err
 }
 
+// searchCustomer returns the ID of the customer for org using the Stripe
+// customer search API, or stripe.ErrNotFound if search finds none.
+func (c *Client) searchCustomer(ctx context.Context, org string) (string, error) {
+	// https://stripe.com/docs/api/customers/search
+	var f stripe.Form
+	f.Set("query", fmt.Sprintf("metadata['tier.org']:'%s'", searchEscaper.Replace(org)))
+	var v struct {
+		Data []stripeCustomer
+	}
+	if err := c.Stripe.Do(ctx, "GET", "/v1/customers/search", f, &v); err != nil {
+		return "", err
+	}
+	for _, cus := range v.Data {
+		if cus.Metadata.Org == org {
+			return cus.ProviderID(), nil
+		}
+	}
+	return "", stripe.ErrNotFound
+}
+
+// searchEscaper escapes values in Stripe search query strings.
+var searchEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
 // LookupOrg returns the org information on file with Stripe, uncached.
 func (c *Client) LookupOrg(ctx context.Context, org string) (*OrgInfo, error) {
 	cid, err := c.WhoIs(ctx, org)
@@ -1095,7 +1176,7 @@ func (c *Client) createCustomer(ctx context.Context, org string, info *OrgInfo)
 		name:    org,
 	}
 
-	return c.cache.load(key, func() (string, error) {
+	return c.cache.load(c.Cache, key, func() (string, error) {
 		var f stripe.Form
 
 		var b strings.Builder
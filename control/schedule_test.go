@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strconv"
 	"sync"
@@ -343,8 +345,13 @@ func (s *scheduleTester) checkInvoices(org string, want []Invoice) {
 		s.t.Fatal(err)
 	}
 	s.t.Logf("got invoices %# v", pretty.Formatter(got))
+	for _, in := range got {
+		if in.Currency != "usd" {
+			s.t.Errorf("Currency = %q; want usd", in.Currency)
+		}
+	}
 	ignorePeriod := diff.KeepFields[Period]()
-	s.diff(got, want, ignorePeriod)
+	s.diff(got, want, ignorePeriod, diff.ZeroFields[Invoice]("Currency"))
 }
 
 func (s *scheduleTester) diff(got, want any, opts ...diff.Option) {
@@ -708,7 +715,7 @@ func TestSchedulePaymentMethod(t *testing.T) {
 			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				t.Logf("fake stripe: %s %s", r.Method, r.URL.Path)
 				switch {
-				case they.Want(r, "GET", "/v1/customers"):
+				case they.Want(r, "GET", "/v1/customers(/search)?"):
 					var c stripeCustomer
 					c.ID = "cust_123"
 					c.Metadata.Org = "org:example"
@@ -999,7 +1006,7 @@ func TestCheckoutRequiredAddress(t *testing.T) {
 	var got []G
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch {
-		case they.Want(r, "GET", "/v1/customers"):
+		case they.Want(r, "GET", "/v1/customers(/search)?"):
 			jsonEncode(t, w, msa{
 				"data": []msa{
 					{
@@ -1103,7 +1110,7 @@ func TestLookupPhasesNoSchedule(t *testing.T) {
 						},
 					]}
 				`)
-			case they.Want(r, "GET", "/v1/customers"):
+			case they.Want(r, "GET", "/v1/customers(/search)?"):
 				writeHuJSON(w, `
 					{"data": [
 						{
@@ -1630,3 +1637,192 @@ func blankIfFalse(b bool) string {
 	}
 	return ""
 }
+
+// fakeCustomers returns a fake Stripe serving n customers for orgs
+// "org:0" through "org:<n-1>" from the customer list and search APIs. If
+// searchErr is not empty, searches fail with it as the error type. The
+// returned counter reports the number of requests made to each path.
+func fakeCustomers(tb testing.TB, n, searchStatus int, searchErr string) (*Client, func(path string) int) {
+	var mu sync.Mutex
+	counts := map[string]int{}
+	customer := func(i int) msa {
+		return msa{
+			"id":       fmt.Sprintf("cus_%d", i),
+			"metadata": msa{"tier.org": fmt.Sprintf("org:%d", i)},
+		}
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.URL.Path]++
+		mu.Unlock()
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			tb.Error(err)
+			return
+		}
+		f, err := url.ParseQuery(string(body))
+		if err != nil {
+			tb.Error(err)
+			return
+		}
+
+		switch {
+		case they.Want(r, "GET", "/v1/customers/search"):
+			if searchErr != "" {
+				w.Header().Set("Stripe-Should-Retry", "false")
+				w.WriteHeader(searchStatus)
+				json.NewEncoder(w).Encode(msa{"error": msa{"type": searchErr}})
+				return
+			}
+			var data []msa
+			var i int
+			if _, err := fmt.Sscanf(f.Get("query"), "metadata['tier.org']:'org:%d'", &i); err == nil && i < n {
+				data = append(data, customer(i))
+			}
+			json.NewEncoder(w).Encode(msa{"data": data})
+		case they.Want(r, "GET", "/v1/customers"):
+			start := 0
+			if s := f.Get("starting_after"); s != "" {
+				fmt.Sscanf(s, "cus_%d", &start)
+				start++
+			}
+			limit, _ := strconv.Atoi(f.Get("limit"))
+			var data []msa
+			for i := start; i < n && len(data) < limit; i++ {
+				data = append(data, customer(i))
+			}
+			json.NewEncoder(w).Encode(msa{"data": data, "has_more": start+len(data) < n})
+		default:
+			tb.Errorf("UNEXPECTED: %s %s", r.Method, r.URL.Path)
+		}
+	})
+	s := httptest.NewServer(h)
+	tb.Cleanup(s.Close)
+
+	c := &Client{
+		Logf: tb.Logf,
+		Stripe: &stripe.Client{
+			BaseURL: s.URL,
+			Limiter: stripe.NewLimiter(math.MaxInt, math.MaxInt),
+		},
+	}
+	return c, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[path]
+	}
+}
+
+func TestWhoIsSearch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("search", func(t *testing.T) {
+		c, count := fakeCustomers(t, 500, 0, "")
+		cid, err := c.WhoIs(ctx, "org:450")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cid != "cus_450" {
+			t.Errorf("cid = %q; want cus_450", cid)
+		}
+		if n := count("/v1/customers/search"); n != 1 {
+			t.Errorf("searches = %d; want 1", n)
+		}
+		if n := count("/v1/customers"); n != 0 {
+			t.Errorf("lists = %d; want 0", n)
+		}
+	})
+
+	t.Run("not_indexed", func(t *testing.T) {
+		// org:600 is not found by search, as if it were created too
+		// recently to be indexed, nor by listing.
+		c, count := fakeCustomers(t, 500, 0, "")
+		_, err := c.WhoIs(ctx, "org:600")
+		if !errors.Is(err, ErrOrgNotFound) {
+			t.Fatalf("err = %v; want %v", err, ErrOrgNotFound)
+		}
+		if n := count("/v1/customers"); n != 5 {
+			t.Errorf("lists = %d; want 5", n)
+		}
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		c, count := fakeCustomers(t, 500, 400, "invalid_request_error")
+		for _, org := range []string{"org:1", "org:2"} {
+			if _, err := c.WhoIs(ctx, org); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := count("/v1/customers/search"); n != 1 {
+			t.Errorf("searches = %d; want 1 before falling back for good", n)
+		}
+		if n := count("/v1/customers"); n != 2 {
+			t.Errorf("lists = %d; want 2", n)
+		}
+	})
+
+	t.Run("rate_limited", func(t *testing.T) {
+		c, count := fakeCustomers(t, 10, 429, "invalid_request_error")
+		for _, org := range []string{"org:1", "org:2"} {
+			if _, err := c.WhoIs(ctx, org); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := count("/v1/customers/search"); n != 2 {
+			t.Errorf("searches = %d; want search kept after a rate limit", n)
+		}
+		if c.searchUnavailable.Load() {
+			t.Error("search disabled after a rate limit")
+		}
+	})
+
+	t.Run("transient", func(t *testing.T) {
+		c, count := fakeCustomers(t, 10, 500, "api_error")
+		for _, org := range []string{"org:1", "org:2"} {
+			if _, err := c.WhoIs(ctx, org); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := count("/v1/customers/search"); n < 2 {
+			t.Errorf("searches = %d; want search retried for each org", n)
+		}
+	})
+}
+
+func TestSearchEscaper(t *testing.T) {
+	got := searchEscaper.Replace(`org:it's\ok`)
+	if want := `org:it\'s\\ok`; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// BenchmarkWhoIs compares looking up orgs with customer search against
+// listing all customers, in an account with 5000 customers. Each iteration
+// looks up a different org, so none are served from the cache.
+func BenchmarkWhoIs(b *testing.B) {
+	const n = 5000
+	for _, bb := range []struct {
+		name      string
+		searchErr string
+	}{
+		{"search", ""},
+		{"list", "invalid_request_error"},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			c, _ := fakeCustomers(b, n, 400, bb.searchErr)
+			c.Logf = func(string, ...any) {}
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				org := fmt.Sprintf("org:%d", (n-1)-i%n)
+				if i >= n {
+					c.cache = memo{}
+				}
+				if _, err := c.WhoIs(ctx, org); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -6,18 +6,33 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"golang.org/x/exp/maps"
 	"kr.dev/errorfmt"
+	"tier.run/metrics"
 	"tier.run/refs"
 	"tier.run/stripe"
 )
 
+var (
+	metricUsageReports = metrics.NewCounterVec("tier_usage_reports_total",
+		"Usage reports sent to Stripe by feature.",
+		"feature")
+	metricUsageReported = metrics.NewCounterVec("tier_usage_reported_units_total",
+		"Units of usage reported to Stripe by feature.",
+		"feature")
+)
+
 type Report struct {
 	N       int
 	At      time.Time
 	Clobber bool
+
+	// IdempotencyKey, if set, is used to dedup reports of the same usage
+	// event for org and feature at Stripe. If empty, a random key is used.
+	IdempotencyKey string
 }
 
 type Usage struct {
@@ -46,9 +61,18 @@ func (c *Client) ReportUsage(ctx context.Context, org string, feature refs.Name,
 		f.Set("action", "increment")
 	}
 
-	f.SetIdempotencyKey(randomString())
+	if use.IdempotencyKey != "" {
+		f.SetIdempotencyKey("usage:" + org + ":" + feature.String() + ":" + use.IdempotencyKey)
+	} else {
+		f.SetIdempotencyKey(randomString())
+	}
 
-	return c.Stripe.Do(ctx, "POST", "/v1/subscription_items/"+itemID+"/usage_records", f, nil)
+	if err := c.Stripe.Do(ctx, "POST", "/v1/subscription_items/"+itemID+"/usage_records", f, nil); err != nil {
+		return err
+	}
+	metricUsageReports.Inc(feature.String())
+	metricUsageReported.Add(float64(use.N), feature.String())
+	return nil
 }
 
 func (c *Client) LookupLimits(ctx context.Context, org string) ([]Usage, error) {
@@ -119,3 +143,166 @@ func randomString() string {
 	}
 	return hex.EncodeToString(b[:])
 }
+
+// A Consumption is the outcome of a call to Consume.
+type Consumption struct {
+	Feature refs.FeaturePlan
+	Allowed bool // whether n units were reported
+	Used    int  // usage after the decision; includes n if Allowed
+	Limit   int
+}
+
+// Remaining reports the number of units that may still be consumed in the
+// current period.
+func (c Consumption) Remaining() int {
+	if c.Limit == Inf {
+		return Inf
+	}
+	if c.Used >= c.Limit {
+		return 0
+	}
+	return c.Limit - c.Used
+}
+
+// Consume checks if org may use n more units of feature without exceeding
+// its limit, and if so, reports n units of usage at the current time.
+//
+// Calls to Consume for the same org and feature are serialized so that two
+// concurrent callers cannot both pass the check and overshoot the limit.
+// Because usage reported to Stripe shows in the upcoming invoice only after a
+// delay, usage Consume reports is counted until Stripe catches up, or until
+// pendingUsageTTL passes. Usage reported by means other than c is only
+// observed once it is visible at Stripe.
+func (c *Client) Consume(ctx context.Context, org string, feature refs.Name, n int) (Consumption, error) {
+	if n < 1 {
+		return Consumption{}, &ValidationError{Message: "n must be greater than zero"}
+	}
+
+	key := consumeKey{
+		orgKey: orgKey{
+			account: c.Stripe.AccountID,
+			clock:   clockFromContext(ctx),
+			name:    org,
+		},
+		feature: feature,
+	}
+	unlock := c.consumeLocks.lock(key)
+	defer unlock()
+
+	usage, err := c.LookupLimits(ctx, org)
+	if err != nil {
+		return Consumption{}, err
+	}
+
+	var u Usage
+	for _, v := range usage {
+		if v.Feature.Name() == feature {
+			u = v
+			break
+		}
+	}
+	if u.Feature.IsZero() {
+		return Consumption{}, fmt.Errorf("%w: %q", ErrFeatureNotFound, feature)
+	}
+
+	cs := Consumption{
+		Feature: u.Feature,
+		Used:    c.usedWithPending(key, u),
+		Limit:   u.Limit,
+	}
+	if u.Limit != Inf && cs.Used+n > u.Limit {
+		return cs, nil
+	}
+	if err := c.ReportUsage(ctx, org, feature, Report{N: n}); err != nil {
+		return Consumption{}, err
+	}
+	cs.Allowed = true
+	cs.Used += n
+	c.setPending(key, pendingUsage{
+		end:    u.End,
+		used:   cs.Used,
+		expire: time.Now().Add(pendingUsageTTL),
+	})
+	return cs, nil
+}
+
+type consumeKey struct {
+	orgKey
+	feature refs.Name
+}
+
+// pendingUsageTTL is how long Consume counts usage it reported that is not
+// yet visible in the upcoming invoice.
+const pendingUsageTTL = 5 * time.Minute
+
+// pendingUsage is the usage Consume expects Stripe to report for a feature
+// once the usage it reported is visible.
+type pendingUsage struct {
+	end    time.Time // end of the period the usage was reported in
+	used   int
+	expire time.Time
+}
+
+// usedWithPending returns the usage of u, including usage reported by
+// Consume that Stripe does not yet reflect. The caller must hold the consume
+// lock for key.
+func (c *Client) usedWithPending(key consumeKey, u Usage) int {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	p, ok := c.pending[key]
+	if !ok {
+		return u.Used
+	}
+	if u.Used >= p.used || !p.end.Equal(u.End) || time.Now().After(p.expire) {
+		// caught up, in a new period, or given up on
+		delete(c.pending, key)
+		return u.Used
+	}
+	return p.used
+}
+
+func (c *Client) setPending(key consumeKey, p pendingUsage) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[consumeKey]pendingUsage)
+	}
+	c.pending[key] = p
+}
+
+// keyedMutex is a set of mutexes keyed by consumeKey. Mutexes are removed
+// once they are no longer held or waited on.
+type keyedMutex struct {
+	mu sync.Mutex
+	m  map[consumeKey]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int // guarded by keyedMutex.mu
+}
+
+func (k *keyedMutex) lock(key consumeKey) (unlock func()) {
+	k.mu.Lock()
+	if k.m == nil {
+		k.m = make(map[consumeKey]*refMutex)
+	}
+	m := k.m[key]
+	if m == nil {
+		m = &refMutex{}
+		k.m[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		m.refs--
+		if m.refs == 0 {
+			delete(k.m, key)
+		}
+		k.mu.Unlock()
+	}
+}
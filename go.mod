@@ -3,13 +3,16 @@ module tier.run
 go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da
 	github.com/kr/pretty v0.3.0
 	github.com/tailscale/hujson v0.0.0-20221223112325-20486734a56a
+	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f
 	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e
 	golang.org/x/mod v0.7.0
 	golang.org/x/sync v0.1.0
 	golang.org/x/tools v0.4.1-0.20221208213631-3f74d914ae6d
+	gopkg.in/yaml.v3 v3.0.1
 	honnef.co/go/tools v0.4.0
 	kr.dev/diff v0.3.1-0.20221219052439-de28753499d5
 	kr.dev/errorfmt v0.1.1
@@ -17,9 +20,7 @@ require (
 )
 
 require (
-	github.com/BurntSushi/toml v1.2.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
-	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f // indirect
 	golang.org/x/exp/typeparams v0.0.0-20221208152030-732eee02a75a // indirect
 	golang.org/x/net v0.3.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
@@ -0,0 +1,274 @@
+// Package metrics implements counters and histograms with labels, and a
+// handler serving them in the Prometheus text exposition format.
+//
+// It implements only what Tier needs, and is not a general replacement for
+// the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry used by NewCounterVec, NewHistogramVec, and
+// Handler.
+var Default = &Registry{}
+
+// DefBuckets are the default histogram buckets, in seconds. They are the same
+// as those used by the Prometheus client library.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	metricName() string
+	writeTo(w io.Writer)
+}
+
+// A Registry is a set of metrics. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.metrics == nil {
+		r.metrics = make(map[string]metric)
+	}
+	if _, ok := r.metrics[m.metricName()]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", m.metricName()))
+	}
+	r.metrics[m.metricName()] = m
+}
+
+// WriteText writes all metrics in r to w in the Prometheus text format, sorted
+// by name.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	ms := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		ms = append(ms, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].metricName() < ms[j].metricName()
+	})
+	for _, m := range ms {
+		m.writeTo(w)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+// Handler returns a handler serving the Default registry.
+func Handler() http.Handler { return Default }
+
+// vec holds the label names and the per label value state common to all
+// metric types.
+type vec[T any] struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*T // keyed by encoded label values
+	newT   func() *T
+}
+
+func (v *vec[T]) metricName() string { return v.name }
+
+func (v *vec[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s: got %d label values; want %d", v.name, len(labelValues), len(v.labels)))
+	}
+	key := encodeLabels(v.labels, labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = make(map[string]*T)
+	}
+	t := v.values[key]
+	if t == nil {
+		t = v.newT()
+		v.values[key] = t
+	}
+	return t
+}
+
+// each calls f for each set of label values in sorted order, while holding
+// v.mu.
+func (v *vec[T]) each(f func(labels string, t *T)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f(k, v.values[k])
+	}
+}
+
+func (v *vec[T]) writeHeader(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, typ)
+}
+
+// A CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	vec[float64]
+}
+
+// NewCounterVec creates and registers a counter with the provided name, help
+// text, and label names with the Default registry. It panics if a metric with
+// the same name is already registered.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[float64]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		newT:   func() *float64 { return new(float64) },
+	}}
+	Default.register(c)
+	return c
+}
+
+// Add adds n to the counter for labelValues. The number of label values must
+// match the number of label names.
+func (c *CounterVec) Add(n float64, labelValues ...string) {
+	p := c.with(labelValues)
+	c.mu.Lock()
+	*p += n
+	c.mu.Unlock()
+}
+
+// Inc is shorthand for Add(1, labelValues...).
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value reports the current value of the counter for labelValues.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	p := c.with(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *p
+}
+
+func (c *CounterVec) writeTo(w io.Writer) {
+	c.writeHeader(w, "counter")
+	c.each(func(labels string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, braces(labels), formatFloat(*v))
+	})
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// A HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+// NewHistogramVec creates and registers a histogram with the provided name,
+// help text, bucket upper bounds, and label names with the Default registry.
+// If buckets is nil, DefBuckets is used. It panics if a metric with the same
+// name is already registered.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = vec[histogram]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		newT: func() *histogram {
+			return &histogram{counts: make([]uint64, len(buckets)+1)}
+		},
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records v in the histogram for labelValues.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	p := h.with(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	p.counts[i]++
+	p.sum += v
+	p.count++
+	h.mu.Unlock()
+}
+
+func (h *HistogramVec) writeTo(w io.Writer) {
+	h.writeHeader(w, "histogram")
+	h.each(func(labels string, v *histogram) {
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += v.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, braces(join(labels, `le="`+formatFloat(le)+`"`)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, braces(join(labels, `le="+Inf"`)), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(labels), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(labels), v.count)
+	})
+}
+
+func encodeLabels(names, values []string) string {
+	var b strings.Builder
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestWriteText(t *testing.T) {
+	defer func(r *Registry) { Default = r }(Default)
+	Default = &Registry{}
+
+	c := NewCounterVec("test_requests_total", "Requests.", "route", "code")
+	c.Inc("/v1/b", "200")
+	c.Inc("/v1/a", "200")
+	c.Add(2, "/v1/a", "200")
+	c.Inc("/v1/\"q\"", "500")
+
+	h := NewHistogramVec("test_duration_seconds", "Durations.", []float64{1, 0.5}, "route")
+	h.Observe(0.1, "/v1/a")
+	h.Observe(0.7, "/v1/a")
+	h.Observe(3, "/v1/a")
+
+	u := NewCounterVec("test_plain_total", "Plain.")
+	u.Inc()
+
+	var b strings.Builder
+	Default.WriteText(&b)
+
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/v1/a",le="0.5"} 1
+test_duration_seconds_bucket{route="/v1/a",le="1"} 2
+test_duration_seconds_bucket{route="/v1/a",le="+Inf"} 3
+test_duration_seconds_sum{route="/v1/a"} 3.8
+test_duration_seconds_count{route="/v1/a"} 3
+# HELP test_plain_total Plain.
+# TYPE test_plain_total counter
+test_plain_total 1
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/v1/\"q\"",code="500"} 1
+test_requests_total{route="/v1/a",code="200"} 3
+test_requests_total{route="/v1/b",code="200"} 1
+`
+	diff.Test(t, t.Errorf, b.String(), want)
+
+	if got := c.Value("/v1/a", "200"); got != 3 {
+		t.Errorf("Value = %v; want 3", got)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	defer func(r *Registry) { Default = r }(Default)
+	Default = &Registry{}
+
+	NewCounterVec("dup", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	NewCounterVec("dup", "")
+}
@@ -0,0 +1,280 @@
+package profile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	ErrNoKey       = errors.New("config is encrypted; set TIER_CONFIG_PASSPHRASE or TIER_CONFIG_KEY_FILE")
+	ErrWrongKey    = errors.New("wrong passphrase or key file for encrypted config")
+	ErrEncrypted   = errors.New("config is already encrypted")
+	ErrUnencrypted = errors.New("config is not encrypted")
+)
+
+// Methods of deriving the key used to encrypt a config.
+const (
+	MethodPassphrase = "passphrase" // from TIER_CONFIG_PASSPHRASE using scrypt
+	MethodKeyFile    = "keyfile"    // from the file named in TIER_CONFIG_KEY_FILE
+)
+
+// encPrefix marks encrypted values in the config file.
+const encPrefix = "enc:"
+
+// minKeyFileBytes is the least a key file may hold, so that keys are not
+// guessable.
+const minKeyFileBytes = 32
+
+// checkValue is encrypted into Encryption.Check to detect a wrong key even
+// if there are no profiles to decrypt.
+const checkValue = "tier"
+
+// Encryption describes how the secret keys of the profiles in a config are
+// encrypted. They are encrypted with AES-256-GCM using a key derived as
+// described by Method.
+type Encryption struct {
+	Method string `json:"method"`
+	Salt   []byte `json:"salt,omitempty"` // for MethodPassphrase
+	Check  string `json:"check"`
+}
+
+// newEncryption returns an Encryption for the key source set in the
+// environment, and the key it derives. TIER_CONFIG_KEY_FILE takes
+// precedence over TIER_CONFIG_PASSPHRASE.
+func newEncryption() (*Encryption, []byte, error) {
+	e := &Encryption{Method: MethodKeyFile}
+	if os.Getenv("TIER_CONFIG_KEY_FILE") == "" {
+		e.Method = MethodPassphrase
+		e.Salt = make([]byte, 16)
+		if _, err := rand.Read(e.Salt); err != nil {
+			return nil, nil, err
+		}
+	}
+	key, err := e.deriveKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	e.Check, err = sealValue(key, checkValue, "check")
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, key, nil
+}
+
+// deriveKey returns the key for e from the environment, and checks it
+// against e.Check if set.
+func (e *Encryption) deriveKey() ([]byte, error) {
+	var key []byte
+	switch e.Method {
+	case MethodPassphrase:
+		pass := os.Getenv("TIER_CONFIG_PASSPHRASE")
+		if pass == "" {
+			return nil, ErrNoKey
+		}
+		var err error
+		key, err = passphraseKey(pass, e.Salt)
+		if err != nil {
+			return nil, err
+		}
+	case MethodKeyFile:
+		name := os.Getenv("TIER_CONFIG_KEY_FILE")
+		if name == "" {
+			return nil, ErrNoKey
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) < minKeyFileBytes {
+			return nil, fmt.Errorf("key file %s must hold at least %d bytes", name, minKeyFileBytes)
+		}
+		sum := sha256.Sum256(data)
+		key = sum[:]
+	default:
+		return nil, fmt.Errorf("unknown config encryption method %q", e.Method)
+	}
+
+	if e.Check != "" {
+		if v, err := openValue(key, e.Check, "check"); err != nil || v != checkValue {
+			return nil, ErrWrongKey
+		}
+	}
+	return key, nil
+}
+
+// passphraseKeys holds the keys derived from passphrases, which are
+// deliberately slow to derive, so that each is derived once per process.
+var passphraseKeys struct {
+	sync.Mutex
+	m map[passphraseSalt][]byte
+}
+
+type passphraseSalt struct {
+	pass, salt string
+}
+
+func passphraseKey(pass string, salt []byte) ([]byte, error) {
+	passphraseKeys.Lock()
+	defer passphraseKeys.Unlock()
+	ps := passphraseSalt{pass, string(salt)}
+	if key := passphraseKeys.m[ps]; key != nil {
+		return key, nil
+	}
+	key, err := scrypt.Key([]byte(pass), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	if passphraseKeys.m == nil {
+		passphraseKeys.m = make(map[passphraseSalt][]byte)
+	}
+	passphraseKeys.m[ps] = key
+	return key, nil
+}
+
+// secrets returns the name of each secret field of p that is set, and a
+// pointer to its value.
+func secrets(p *Profile) map[string]*string {
+	m := map[string]*string{}
+	if p.LiveAPIKey != "" {
+		m["livemode_key_secret"] = &p.LiveAPIKey
+	}
+	if p.TestAPIKey != "" {
+		m["testmode_key_secret"] = &p.TestAPIKey
+	}
+	return m
+}
+
+// decryptProfile returns a copy of the profile saved as name with its
+// secret fields decrypted with key.
+func decryptProfile(key []byte, name string, p *Profile) (*Profile, error) {
+	out := *p
+	for field, v := range secrets(&out) {
+		s, err := openValue(key, *v, name+"/"+field)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %s: %w", name, field, err)
+		}
+		*v = s
+	}
+	return &out, nil
+}
+
+// encryptProfile returns a copy of p with its secret fields encrypted with
+// key, to be saved as name.
+func encryptProfile(key []byte, name string, p *Profile) (*Profile, error) {
+	out := *p
+	for field, v := range secrets(&out) {
+		s, err := sealValue(key, *v, name+"/"+field)
+		if err != nil {
+			return nil, err
+		}
+		*v = s
+	}
+	return &out, nil
+}
+
+// sealValue encrypts plaintext with key, binding it to ad so that it can not
+// be moved to another field undetected.
+func sealValue(key []byte, plaintext, ad string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b := aead.Seal(nonce, nonce, []byte(plaintext), []byte(ad))
+	return encPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// openValue decrypts a value made by sealValue.
+func openValue(key []byte, s, ad string) (string, error) {
+	b64, ok := strings.CutPrefix(s, encPrefix)
+	if !ok {
+		return "", errors.New("value is not encrypted")
+	}
+	b, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(b) < aead.NonceSize() {
+		return "", errors.New("encrypted value too short")
+	}
+	pt, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(ad))
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(pt), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts the secret keys of the profiles in the config file in
+// place, using the key file named in TIER_CONFIG_KEY_FILE if set, or else
+// the passphrase in TIER_CONFIG_PASSPHRASE. Once encrypted, the same
+// variable must be set to load and save profiles.
+func Encrypt() error {
+	c, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if c.Encryption != nil {
+		return ErrEncrypted
+	}
+	e, key, err := newEncryption()
+	if err != nil {
+		return err
+	}
+	for name, p := range c.Profiles {
+		if c.Profiles[name], err = encryptProfile(key, name, p); err != nil {
+			return err
+		}
+	}
+	c.Encryption = e
+	return SaveConfig(c)
+}
+
+// Decrypt decrypts the secret keys of the profiles in the config file in
+// place, undoing Encrypt.
+func Decrypt() error {
+	c, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if c.Encryption == nil {
+		return ErrUnencrypted
+	}
+	key, err := c.Encryption.deriveKey()
+	if err != nil {
+		return err
+	}
+	for name, p := range c.Profiles {
+		if c.Profiles[name], err = decryptProfile(key, name, p); err != nil {
+			return err
+		}
+	}
+	c.Encryption = nil
+	return SaveConfig(c)
+}
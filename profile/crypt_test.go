@@ -0,0 +1,171 @@
+package profile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKey = "sk_test_0123456789abcdef"
+
+func setup(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("TIER_CONFIG_PASSPHRASE", "")
+	t.Setenv("TIER_CONFIG_KEY_FILE", "")
+	if err := Save("tier", &Profile{AccountID: "acct_1", TestAPIKey: testKey}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkKey(t *testing.T) {
+	t.Helper()
+	p, err := Load("tier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TestAPIKey != testKey {
+		t.Errorf("TestAPIKey = %q; want %q", p.TestAPIKey, testKey)
+	}
+}
+
+func checkFile(t *testing.T, wantPlain bool) {
+	t.Helper()
+	data, err := os.ReadFile(ConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Contains(string(data), testKey); got != wantPlain {
+		t.Errorf("plaintext key in config file = %v; want %v", got, wantPlain)
+	}
+}
+
+func TestEncryptPassphrase(t *testing.T) {
+	setup(t)
+	if err := Encrypt(); err == nil {
+		t.Fatal("expected error encrypting without a passphrase or key file")
+	}
+
+	t.Setenv("TIER_CONFIG_PASSPHRASE", "hunter2")
+	if err := Encrypt(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, false)
+	checkKey(t)
+	if err := Encrypt(); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("err = %v; want ErrEncrypted", err)
+	}
+
+	// profiles saved after encrypting are encrypted too
+	if err := Save("work", &Profile{AccountID: "acct_2", LiveAPIKey: "sk_live_abc"}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.LiveAPIKey != "sk_live_abc" {
+		t.Errorf("LiveAPIKey = %q; want sk_live_abc", p.LiveAPIKey)
+	}
+	checkFile(t, false)
+
+	t.Setenv("TIER_CONFIG_PASSPHRASE", "wrong")
+	if _, err := Load("tier"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("err = %v; want ErrWrongKey", err)
+	}
+	t.Setenv("TIER_CONFIG_PASSPHRASE", "")
+	if _, err := Load("tier"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("err = %v; want ErrNoKey", err)
+	}
+
+	// selecting profiles does not need the key
+	if err := Use("work"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.CurrentName(); got != "work" {
+		t.Errorf("CurrentName() = %q; want work", got)
+	}
+
+	t.Setenv("TIER_CONFIG_PASSPHRASE", "hunter2")
+	if err := Decrypt(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, true)
+	t.Setenv("TIER_CONFIG_PASSPHRASE", "")
+	checkKey(t)
+	if err := Decrypt(); !errors.Is(err, ErrUnencrypted) {
+		t.Errorf("err = %v; want ErrUnencrypted", err)
+	}
+}
+
+func TestEncryptKeyFile(t *testing.T) {
+	setup(t)
+	dir := t.TempDir()
+	short := filepath.Join(dir, "short")
+	if err := os.WriteFile(short, []byte("too short\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TIER_CONFIG_KEY_FILE", short)
+	if err := Encrypt(); err == nil {
+		t.Fatal("expected error encrypting with a short key file")
+	}
+
+	name := filepath.Join(dir, "key")
+	if err := os.WriteFile(name, []byte(strings.Repeat("k", 64)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TIER_CONFIG_KEY_FILE", name)
+	t.Setenv("TIER_CONFIG_PASSPHRASE", "ignored")
+	if err := Encrypt(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Encryption.Method != MethodKeyFile {
+		t.Errorf("Method = %q; want %q", c.Encryption.Method, MethodKeyFile)
+	}
+	checkFile(t, false)
+	checkKey(t)
+
+	other := filepath.Join(dir, "other")
+	if err := os.WriteFile(other, []byte(strings.Repeat("o", 64)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TIER_CONFIG_KEY_FILE", other)
+	if _, err := Load("tier"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("err = %v; want ErrWrongKey", err)
+	}
+}
+
+func TestSaveConfigReplacesFile(t *testing.T) {
+	setup(t)
+	if err := Save("work", &Profile{AccountID: "acct_2"}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(ConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0o600 {
+		t.Errorf("mode = %o; want 600", mode)
+	}
+	des, err := os.ReadDir(filepath.Dir(ConfigPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(des) != 1 {
+		var names []string
+		for _, de := range des {
+			names = append(names, de.Name())
+		}
+		t.Errorf("files = %q; want only config.json", names)
+	}
+	checkKey(t)
+}
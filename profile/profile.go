@@ -10,6 +10,10 @@ import (
 
 var ErrProfileNotFound = errors.New("profile not found")
 
+// DefaultName is the name of the profile used when no other is selected. It
+// is the name tier connect has always saved profiles under.
+const DefaultName = "tier"
+
 type Profile struct {
 	Redeemed           bool   `json:"redeemed"`
 	AccountID          string `json:"account_id"`
@@ -27,8 +31,27 @@ type Profiles map[string]*Profile
 
 type Config struct {
 	Profiles Profiles `json:"profiles"`
+
+	// Current is the name of the profile selected with "tier profiles
+	// use". If empty, DefaultName is used.
+	Current string `json:"current,omitempty"`
+
+	// Encryption, if set, describes how the secret keys of the profiles
+	// are encrypted in the config file. See Encrypt.
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// CurrentName returns the name of the profile selected in c, or DefaultName
+// if none is.
+func (c *Config) CurrentName() string {
+	if c.Current != "" {
+		return c.Current
+	}
+	return DefaultName
 }
 
+// Load returns the named profile, with its secret keys decrypted if the
+// config is encrypted.
 func Load(name string) (*Profile, error) {
 	c, err := LoadConfig()
 	if err != nil {
@@ -38,9 +61,19 @@ func Load(name string) (*Profile, error) {
 	if p == nil {
 		return nil, ErrProfileNotFound
 	}
-	return p, nil
+	if c.Encryption == nil {
+		return p, nil
+	}
+	key, err := c.Encryption.deriveKey()
+	if err != nil {
+		return nil, err
+	}
+	return decryptProfile(key, name, p)
 }
 
+// LoadConfig returns the config in the config file. The secret keys of its
+// profiles are left encrypted if the config is encrypted; use Load to read
+// them.
 func LoadConfig() (*Config, error) {
 	f, err := open()
 	if err != nil {
@@ -65,26 +98,93 @@ func LoadConfig() (*Config, error) {
 	return c, nil
 }
 
+// Save saves p under name, encrypting its secret keys if the config is
+// encrypted.
 func Save(name string, p *Profile) error {
 	c, err := LoadConfig()
 	if err != nil {
 		return err
 	}
+	if c.Encryption != nil {
+		key, err := c.Encryption.deriveKey()
+		if err != nil {
+			return err
+		}
+		p, err = encryptProfile(key, name, p)
+		if err != nil {
+			return err
+		}
+	}
 
 	if c.Profiles == nil {
 		c.Profiles = make(Profiles)
 	}
 	c.Profiles[name] = p
+	return SaveConfig(c)
+}
 
-	f, err := open()
+// Use selects the named profile as the current profile. It returns
+// ErrProfileNotFound if there is no profile with the name.
+func Use(name string) error {
+	c, err := LoadConfig()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if c.Profiles[name] == nil {
+		return ErrProfileNotFound
+	}
+	c.Current = name
+	return SaveConfig(c)
+}
+
+// Remove removes the named profile. If it is the current profile,
+// DefaultName becomes current. It returns ErrProfileNotFound if there is no
+// profile with the name.
+func Remove(name string) error {
+	c, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if c.Profiles[name] == nil {
+		return ErrProfileNotFound
+	}
+	delete(c.Profiles, name)
+	if c.Current == name {
+		c.Current = ""
+	}
+	return SaveConfig(c)
+}
+
+// SaveConfig replaces the config file with c as is. The secret keys of the
+// profiles in an encrypted config must already be encrypted, as LoadConfig
+// leaves them. The file is replaced atomically, so that a failure to write
+// it does not lose the profiles in it.
+func SaveConfig(c *Config) error {
+	path := ConfigPath()
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".config-*.json") // created with mode 0600
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails once renamed
 
 	e := json.NewEncoder(f)
 	e.SetIndent("", "    ")
-	return e.Encode(c)
+	if err := e.Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
 // ConfigPath returns the path to the config file.
@@ -8,10 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	mrand "math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -61,6 +64,7 @@ func Link(live bool, accountID string, parts ...string) (string, error) {
 
 type Error struct {
 	AccountID string
+	Status    int `json:"-"` // the HTTP status code of the response
 	Type      string
 	Code      string
 	Param     string
@@ -195,6 +199,19 @@ type Client struct {
 	KeyPrefix string
 
 	Version string // default is 2022-11-15
+
+	// MaxAttempts is the maximum number of attempts made for each request
+	// before giving up. The default is 3.
+	MaxAttempts int
+
+	// Limiter limits the rate of requests made to Stripe, including
+	// retries. It is shared by clones of the client. If nil, a limiter
+	// allowing DefaultLiveRate or DefaultTestRate requests per second,
+	// depending on the mode of APIKey, is used.
+	Limiter *Limiter
+
+	limiterOnce sync.Once
+	limiter     *Limiter
 }
 
 func FromEnv() (*Client, error) {
@@ -235,24 +252,83 @@ func (c *Client) baseURL() string {
 	return "https://api.stripe.com"
 }
 
+// Do sends a request to Stripe and decodes the response into out, if out is
+// not nil.
+//
+// Requests that fail due to connection errors, rate limiting (429), lock
+// timeouts (409), or server errors (5XX) are retried with backoff up to
+// MaxAttempts times. Stripe's Stripe-Should-Retry and Retry-After response
+// headers are honored. GET and DELETE requests are safe to retry as is. POST
+// requests without an idempotency key are given a generated one so that
+// retries are not applied twice.
 func (c *Client) Do(ctx context.Context, method, path string, f Form, out any) error {
 	urlStr, err := url.JoinPath(c.baseURL(), path)
 	if err != nil {
 		return err
 	}
 
+	key := f.idempotencyKey
+	if key == "" && method == "POST" {
+		key = "auto:" + randomString()
+	}
+	if key != "" && c.KeyPrefix != "" {
+		key = c.KeyPrefix + "#" + key
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, after, err := c.do(ctx, method, path, urlStr, key, f, out)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.maxAttempts() {
+			return err
+		}
+		d := retryDelay(attempt, after)
+		c.logf("stripe: %s %s: attempt %d of %d failed: %v; retrying in %v", method, path, attempt, c.maxAttempts(), err, d)
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// do makes a single attempt at a request. It reports if the request may be
+// retried, and the delay Stripe asked for before retrying, if any.
+func (c *Client) do(ctx context.Context, method, path, urlStr, key string, f Form, out any) (retry bool, after time.Duration, err error) {
+	if err := c.rateLimiter().Wait(ctx); err != nil {
+		return false, 0, err
+	}
+
+	path = metricPath(path)
+	start := time.Now()
+	defer func() {
+		metricRequestDuration.Observe(time.Since(start).Seconds(), method, path)
+		var e *Error
+		switch {
+		case errors.As(err, &e):
+			code := e.Code
+			if code == "" {
+				code = e.Type
+			}
+			metricErrors.Inc(path, code)
+		case errors.Is(err, ErrInvalidAPIKey):
+			metricErrors.Inc(path, "invalid_api_key")
+		case err != nil:
+			metricErrors.Inc(path, "client_error")
+		}
+	}()
+
 	req, err := http.NewRequestWithContext(ctx, method, urlStr, strings.NewReader(f.Encode()))
 	if err != nil {
-		return err
+		return false, 0, err
 	}
 	req.SetBasicAuth(c.APIKey, "")
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Stripe-Version", c.version())
-	if f.idempotencyKey != "" {
-		key := f.idempotencyKey
-		if c.KeyPrefix != "" {
-			key = c.KeyPrefix + "#" + key
-		}
+	if key != "" {
 		req.Header.Set("Idempotency-Key", key)
 	}
 	if c.AccountID != "" {
@@ -261,9 +337,13 @@ func (c *Client) Do(ctx context.Context, method, path string, f Form, out any) e
 
 	resp, err := c.client().Do(req)
 	if err != nil {
-		return err
+		metricRequests.Inc(method, path, "error")
+		// connection errors are retryable unless we gave up
+		return ctx.Err() == nil, 0, err
 	}
 	defer resp.Body.Close()
+	metricRequests.Inc(method, path, strconv.Itoa(resp.StatusCode))
+	recordRequestID(ctx, resp.Header.Get("Request-Id"))
 
 	body := io.Reader(resp.Body)
 	if debugMode {
@@ -294,24 +374,108 @@ func (c *Client) Do(ctx context.Context, method, path string, f Form, out any) e
 			Error *Error
 		}
 		if err := json.NewDecoder(body).Decode(&e); err != nil {
-			return fmt.Errorf("stripe: error parsing error response: %w", err)
+			return shouldRetry(resp, nil), retryAfter(resp), fmt.Errorf("stripe: error parsing error response: %w", err)
 		}
 		err := e.Error
 		if err != nil {
 			err.AccountID = c.AccountID
+			err.Status = resp.StatusCode
 			err.RequestID = resp.Header.Get("Request-Id")
 			if isInvalidAPIKey(err) {
-				return ErrInvalidAPIKey
+				return false, 0, ErrInvalidAPIKey
 			}
-			return err
+			return shouldRetry(resp, err), retryAfter(resp), err
 		} else {
-			return fmt.Errorf("stripe: expected error in response: %s", resp.Status)
+			return shouldRetry(resp, nil), retryAfter(resp), fmt.Errorf("stripe: expected error in response: %s", resp.Status)
 		}
 	}
 	if out != nil {
-		return json.NewDecoder(body).Decode(out)
+		return false, 0, json.NewDecoder(body).Decode(out)
+	}
+	return false, 0, nil
+}
+
+// shouldRetry reports if a request that resulted in resp and e may be
+// retried.
+func shouldRetry(resp *http.Response, e *Error) bool {
+	switch resp.Header.Get("Stripe-Should-Retry") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode == http.StatusConflict:
+		return e != nil && e.Code == "lock_timeout"
+	case resp.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay requested in the Retry-After header of resp,
+// if any.
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// Retry delays; variables for testing.
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+)
+
+// retryDelay returns the time to wait before the next attempt after attempt
+// failed. If after is non-zero, it is used instead of exponential backoff,
+// but not past retryMaxDelay.
+func retryDelay(attempt int, after time.Duration) time.Duration {
+	if after > retryMaxDelay {
+		return retryMaxDelay
+	}
+	if after > 0 {
+		return after
+	}
+	d := retryBaseDelay << (attempt - 1)
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	// wait a random time in [d/2, d] to spread out retries from concurrent
+	// requests
+	return d/2 + time.Duration(mrand.Int63n(int64(d/2)+1))
+}
+
+// rateLimiter returns the Limiter for c.
+func (c *Client) rateLimiter() *Limiter {
+	if c.Limiter != nil {
+		return c.Limiter
+	}
+	c.limiterOnce.Do(func() {
+		if c.Live() {
+			c.limiter = NewLimiter(DefaultLiveRate, DefaultLiveRate)
+		} else {
+			c.limiter = NewLimiter(DefaultTestRate, DefaultTestRate)
+		}
+	})
+	return c.limiter
+}
+
+func (c *Client) maxAttempts() int {
+	if c.MaxAttempts > 0 {
+		return c.MaxAttempts
+	}
+	return 3
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
 	}
-	return nil
 }
 
 func (c *Client) CloneAs(accountID string) *Client {
@@ -322,6 +486,10 @@ func (c *Client) CloneAs(accountID string) *Client {
 		AccountID:  accountID,
 		KeyPrefix:  c.KeyPrefix,
 		Logf:       c.Logf,
+
+		Version:     c.Version,
+		MaxAttempts: c.MaxAttempts,
+		Limiter:     c.rateLimiter(),
 	}
 }
 
@@ -2,9 +2,14 @@ package stripe
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func newTestClient(t *testing.T, h func(w http.ResponseWriter, r *http.Request)) *Client {
@@ -93,3 +98,150 @@ func TestInvalidAPIKey(t *testing.T) {
 		t.Errorf("got %v; want %v", err, ErrInvalidAPIKey)
 	}
 }
+
+func TestRetry(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	cases := []struct {
+		method    string
+		status    int
+		code      string
+		header    string // Stripe-Should-Retry
+		wantTries int
+	}{
+		{"GET", 429, "rate_limit", "", 3},
+		{"GET", 500, "", "", 3},
+		{"GET", 503, "", "false", 1},
+		{"GET", 400, "", "", 1},
+		{"GET", 400, "", "true", 3},
+		{"POST", 409, "lock_timeout", "", 3},
+		{"POST", 409, "idempotency_key_in_use", "", 1},
+		{"DELETE", 502, "", "", 3},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprintf("%s/%d/%s/%s", tt.method, tt.status, tt.code, tt.header), func(t *testing.T) {
+			var mu sync.Mutex
+			var keys []string
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				keys = append(keys, r.Header.Get("Idempotency-Key"))
+				mu.Unlock()
+				if tt.header != "" {
+					w.Header().Set("Stripe-Should-Retry", tt.header)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprintf(w, `{"error": {"code": %q}}`, tt.code)
+			})
+
+			err := c.Do(context.Background(), tt.method, "/", Form{}, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if len(keys) != tt.wantTries {
+				t.Errorf("tries = %d; want %d", len(keys), tt.wantTries)
+			}
+			for _, k := range keys {
+				if k != keys[0] {
+					t.Errorf("idempotency keys differ across attempts: %q", keys)
+					break
+				}
+			}
+			if tt.method == "POST" && keys[0] == "" {
+				t.Error("expected generated idempotency key for POST")
+			}
+		})
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	var n atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(429)
+			io.WriteString(w, `{"error": {"code": "rate_limit"}}`)
+			return
+		}
+		io.WriteString(w, `{"id": "cus_123"}`)
+	})
+
+	var v struct{ ID string }
+	if err := c.Do(context.Background(), "GET", "/v1/customers/cus_123", Form{}, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != "cus_123" {
+		t.Errorf("ID = %q; want %q", v.ID, "cus_123")
+	}
+	if n.Load() != 2 {
+		t.Errorf("tries = %d; want 2", n.Load())
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	var n atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		n.Add(1)
+		w.WriteHeader(500)
+		io.WriteString(w, `{"error": {}}`)
+	})
+	c.MaxAttempts = 1
+	if err := c.Do(context.Background(), "GET", "/", Form{}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if n.Load() != 1 {
+		t.Errorf("tries = %d; want 1", n.Load())
+	}
+}
+
+func TestMetricPath(t *testing.T) {
+	cases := []struct {
+		path, want string
+	}{
+		{"/v1/customers", "/v1/customers"},
+		{"/v1/customers/cus_N2b3Xy", "/v1/customers/{id}"},
+		{"/v1/subscription_items/si_123/usage_records", "/v1/subscription_items/{id}/usage_records"},
+		{"/v1/test_helpers/test_clocks/clock_1Mx/advance", "/v1/test_helpers/test_clocks/{id}/advance"},
+		{"/v1/products/tier__plan-free-1", "/v1/products/{id}"},
+		{"/v1/invoices/upcoming/lines", "/v1/invoices/upcoming/lines"},
+	}
+	for _, tt := range cases {
+		if got := metricPath(tt.path); got != tt.want {
+			t.Errorf("metricPath(%q) = %q; want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	var n atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		i := n.Add(1)
+		w.Header().Set("Request-Id", fmt.Sprintf("req_%d", i))
+		if i == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(429)
+			io.WriteString(w, `{"error": {"code": "rate_limit"}}`)
+			return
+		}
+		io.WriteString(w, `{}`)
+	})
+
+	ctx, ids := WithRequestIDs(context.Background())
+	if err := c.Do(ctx, "GET", "/v1/customers/cus_123", Form{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(context.Background(), "GET", "/v1/customers/cus_123", Form{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(ids.List())
+	if want := "[req_1 req_2]"; got != want {
+		t.Errorf("request IDs = %s; want %s", got, want)
+	}
+}
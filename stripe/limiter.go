@@ -0,0 +1,111 @@
+package stripe
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default rate limits, in requests per second. They are a little under the
+// limits Stripe enforces for live and test mode.
+const (
+	DefaultLiveRate = 90
+	DefaultTestRate = 20
+)
+
+// A Limiter is a token bucket rate limiter. It allows bursts of up to Burst
+// requests, refilling at Rate requests per second. It is safe for concurrent
+// use.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	waits  atomic.Int64 // number of calls to Wait that were delayed
+	waited atomic.Int64 // total nanoseconds spent waiting
+}
+
+// NewLimiter returns a Limiter that allows rate requests per second with
+// bursts of up to burst requests. If burst is less than one, it is set to one.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// Wait blocks until a request is allowed or ctx is done. It returns ctx.Err()
+// if ctx is done before the request is allowed.
+func (l *Limiter) Wait(ctx context.Context) error {
+	d := l.reserve()
+	if d <= 0 {
+		return nil
+	}
+	l.waits.Add(1)
+	metricRateLimitWaits.Inc()
+	start := time.Now()
+	defer func() {
+		waited := time.Since(start)
+		l.waited.Add(int64(waited))
+		metricRateLimitWaited.Add(waited.Seconds())
+	}()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns the time to
+// wait until the token is available.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 || l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns a token taken by reserve.
+func (l *Limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+}
+
+// LimiterStats holds statistics about time spent waiting on a Limiter.
+type LimiterStats struct {
+	Waits  int64         // number of requests delayed
+	Waited time.Duration // total time spent waiting
+}
+
+// Stats reports statistics about time spent waiting on l.
+func (l *Limiter) Stats() LimiterStats {
+	return LimiterStats{
+		Waits:  l.waits.Load(),
+		Waited: time.Duration(l.waited.Load()),
+	}
+}
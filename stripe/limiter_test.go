@@ -0,0 +1,49 @@
+package stripe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(100, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two burst tokens, then two more at 100/s
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("elapsed = %v; want at least 15ms", d)
+	}
+	st := l.Stats()
+	if st.Waits != 2 {
+		t.Errorf("Waits = %d; want 2", st.Waits)
+	}
+	if st.Waited <= 0 {
+		t.Errorf("Waited = %v; want > 0", st.Waited)
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l := NewLimiter(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("err = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestClientSharesLimiter(t *testing.T) {
+	c := &Client{APIKey: "sk_test_123"}
+	if c.CloneAs("acct_123").Limiter != c.rateLimiter() {
+		t.Error("clone does not share limiter")
+	}
+}
@@ -109,7 +109,6 @@ func (i *Iterator[I]) refill() error {
 
 // Slurp returns each I over all pages ln a list, or an error if any.
 func Slurp[I Identifiable](ctx context.Context, c *Client, method, path string, f Form) ([]I, error) {
-	// TODO(bmizerany): respect some rate-limiter (maybe in c?)
 	f.Set("limit", 100)
 
 	// TODO(bmizerany): grow slice as needed? currently it grows by one per
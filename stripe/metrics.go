@@ -0,0 +1,47 @@
+package stripe
+
+import (
+	"strings"
+	"unicode"
+
+	"tier.run/metrics"
+)
+
+var (
+	metricRequests = metrics.NewCounterVec("tier_stripe_requests_total",
+		"Stripe API requests by method, path, and HTTP status code.",
+		"method", "path", "status")
+	metricRequestDuration = metrics.NewHistogramVec("tier_stripe_request_duration_seconds",
+		"Latency of Stripe API requests.", nil,
+		"method", "path")
+	metricErrors = metrics.NewCounterVec("tier_stripe_errors_total",
+		"Stripe API errors by path and Stripe error code.",
+		"path", "code")
+	metricRateLimitWaits = metrics.NewCounterVec("tier_stripe_ratelimit_waits_total",
+		"Stripe API requests delayed by the client-side rate limiter.")
+	metricRateLimitWaited = metrics.NewCounterVec("tier_stripe_ratelimit_wait_seconds_total",
+		"Time spent waiting on the client-side rate limiter.")
+)
+
+// metricPath returns path with object IDs replaced by "{id}" to keep the
+// number of distinct label values small.
+//
+// A path segment is considered an ID if it contains an upper case letter or a
+// digit, or if it is a Tier generated ID (see MakeID). Stripe resource names
+// never do.
+func metricPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if p == "v1" {
+			continue
+		}
+		if strings.HasPrefix(p, "tier__") || strings.IndexFunc(p, isIDRune) >= 0 {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isIDRune(r rune) bool {
+	return unicode.IsUpper(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,41 @@
+package stripe
+
+import (
+	"context"
+	"sync"
+)
+
+type requestIDsKey struct{}
+
+// RequestIDs records the IDs Stripe assigned to requests made with a context
+// returned by WithRequestIDs. It is safe for concurrent use.
+type RequestIDs struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+// WithRequestIDs returns a context that records the IDs of requests made
+// with it, including retries and failed requests, in the returned
+// RequestIDs.
+func WithRequestIDs(ctx context.Context) (context.Context, *RequestIDs) {
+	ids := &RequestIDs{}
+	return context.WithValue(ctx, requestIDsKey{}, ids), ids
+}
+
+// List returns the request IDs recorded so far, in the order the
+// responses were received.
+func (r *RequestIDs) List() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+func recordRequestID(ctx context.Context, id string) {
+	r, _ := ctx.Value(requestIDsKey{}).(*RequestIDs)
+	if r == nil || id == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids = append(r.ids, id)
+}
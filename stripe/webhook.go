@@ -0,0 +1,62 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWebhookTolerance is the maximum age of a webhook event signature
+// accepted by VerifyWebhook, as recommended by Stripe.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+var (
+	ErrNoSignature      = errors.New("stripe: webhook has no valid signature")
+	ErrSignatureExpired = errors.New("stripe: webhook signature too old")
+)
+
+// VerifyWebhook reports an error if header, the value of the
+// Stripe-Signature header of a webhook request, is not a signature of
+// payload using secret made within tolerance of now.
+//
+// See https://stripe.com/docs/webhooks/signatures.
+func VerifyWebhook(payload []byte, header, secret string, tolerance time.Duration) error {
+	var t string
+	var sigs [][]byte
+	for _, kv := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			sig, err := hex.DecodeString(v)
+			if err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	sec, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrNoSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	want := mac.Sum(nil)
+
+	for _, sig := range sigs {
+		if hmac.Equal(sig, want) {
+			if time.Since(time.Unix(sec, 0)) > tolerance {
+				return ErrSignatureExpired
+			}
+			return nil
+		}
+	}
+	return ErrNoSignature
+}
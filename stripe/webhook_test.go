@@ -0,0 +1,42 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func sign(payload, secret string, t time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", t.Unix(), payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	const payload = `{"type": "price.updated"}`
+	now := time.Now()
+	old := now.Add(-time.Hour)
+
+	cases := []struct {
+		header string
+		want   error
+	}{
+		{fmt.Sprintf("t=%d,v1=%s", now.Unix(), sign(payload, "whsec_a", now)), nil},
+		{fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), sign(payload, "whsec_old", now), sign(payload, "whsec_a", now)), nil},
+		{fmt.Sprintf("t=%d,v0=%s", now.Unix(), sign(payload, "whsec_a", now)), ErrNoSignature},
+		{fmt.Sprintf("t=%d,v1=%s", now.Unix(), sign(payload, "whsec_b", now)), ErrNoSignature},
+		{fmt.Sprintf("t=%d,v1=%s", now.Unix()+1, sign(payload, "whsec_a", now)), ErrNoSignature},
+		{fmt.Sprintf("t=%d,v1=%s", old.Unix(), sign(payload, "whsec_a", old)), ErrSignatureExpired},
+		{"v1=" + sign(payload, "whsec_a", now), ErrNoSignature},
+		{"", ErrNoSignature},
+	}
+	for _, tt := range cases {
+		err := VerifyWebhook([]byte(payload), tt.header, "whsec_a", DefaultWebhookTolerance)
+		if err != tt.want {
+			t.Errorf("VerifyWebhook(%q) = %v; want %v", tt.header, err, tt.want)
+		}
+	}
+}
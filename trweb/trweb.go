@@ -19,6 +19,7 @@ type HTTPError struct {
 var (
 	NotFound         = &HTTPError{Status: 404, Code: "not_found", Message: "Not Found"}
 	Unauthorized     = &HTTPError{Status: 401, Code: "unauthorized", Message: "Unauthorized"}
+	Forbidden        = &HTTPError{Status: 403, Code: "forbidden", Message: "Forbidden"}
 	InternalError    = &HTTPError{Status: 500, Code: "internal_error", Message: "Internal Server Error"}
 	MethodNotAllowed = &HTTPError{Status: 405, Code: "method_not_allowed", Message: "Method Not Allowed"}
 	InvalidRequest   = &HTTPError{Status: 400, Code: "invalid_request", Message: "Invalid Request"}